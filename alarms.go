@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/ingmarstein/velux-nibe/nibe"
+)
+
+// minAlarmInterval limits how often NIBE Uplink is polled for alarms.
+const minAlarmInterval = time.Minute
+
+// AlarmWatcher polls NIBE Uplink for active alarms and reports the alarms
+// which were raised or cleared since the previous check.
+type AlarmWatcher struct {
+	Client *nibe.Client
+	// OnChange is called for every system whose active alarms changed, and
+	// for every system on its first check.
+	OnChange func(system int, active, raised, cleared []nibe.Notification)
+	// Reported holds the IDs of the alarms which were active during the last
+	// check of an earlier process, by system. They are not reported as raised
+	// again.
+	Reported map[int][]int
+
+	active  map[int]map[int]nibe.Notification
+	checked map[int]bool
+}
+
+// Check compares the active alarms of the given systems with the ones seen
+// during the previous check. Alarms which are active on the first check are
+// reported as raised unless they are listed in Reported.
+func (w *AlarmWatcher) Check(ctx context.Context, systems []int) {
+	if w.active == nil {
+		w.active = make(map[int]map[int]nibe.Notification)
+		for system, ids := range w.Reported {
+			w.active[system] = make(map[int]nibe.Notification)
+			for _, id := range ids {
+				w.active[system][id] = nibe.Notification{NotificationID: id}
+			}
+		}
+	}
+	if w.checked == nil {
+		w.checked = make(map[int]bool)
+	}
+
+	for _, system := range systems {
+		response, err := w.Client.GetNotifications(ctx, nibe.GetNotificationsRequest{
+			SystemID:         system,
+			Active:           true,
+			NotificationType: nibe.NotificationTypeAlarm,
+			ItemsPerPage:     100,
+		})
+		if err != nil {
+			nibeLog.Error("Failed to read alarms", "system", system, "error", err)
+			continue
+		}
+
+		previous := w.active[system]
+		current := make(map[int]nibe.Notification)
+		var active, raised, cleared []nibe.Notification
+		for _, n := range response.Objects {
+			current[n.NotificationID] = n
+			active = append(active, n)
+			if _, ok := previous[n.NotificationID]; !ok {
+				raised = append(raised, n)
+			}
+		}
+		for id, n := range previous {
+			if _, ok := current[id]; !ok {
+				cleared = append(cleared, n)
+			}
+		}
+		sort.Slice(cleared, func(i, j int) bool { return cleared[i].NotificationID < cleared[j].NotificationID })
+		w.active[system] = current
+
+		if len(raised) > 0 || len(cleared) > 0 || !w.checked[system] {
+			w.checked[system] = true
+			w.OnChange(system, active, raised, cleared)
+		}
+	}
+}
+
+// watchAlarms periodically checks the configured NIBE systems for alarms,
+// logs changes and makes the active alarms available to the HTML interface.
+func (state *SystemState) watchAlarms(ctx context.Context) {
+	state.SettingsMu.RLock()
+	watcher := &AlarmWatcher{
+		Client:   state.nibeClient,
+		OnChange: state.alarmsChanged,
+		Reported: state.Runtime.Alarms,
+	}
+	interval := max(time.Duration(state.Settings.PollInterval)*time.Second, minAlarmInterval)
+	state.SettingsMu.RUnlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		state.SettingsMu.RLock()
+		systems := state.Settings.configuredSystems()
+		// pick up interval changes from reloaded settings
+		if i := max(time.Duration(state.Settings.PollInterval)*time.Second, minAlarmInterval); i != interval {
+			interval = i
+			ticker.Reset(interval)
+		}
+		state.SettingsMu.RUnlock()
+
+		watcher.Check(ctx, systems)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (state *SystemState) alarmsChanged(system int, active, raised, cleared []nibe.Notification) {
+	for _, n := range raised {
+		nibeLog.Warn("Alarm raised", "system", system, "alarm", n.AlarmNumber, "header", n.Header, "description", n.Description, "equipment", n.EquipName)
+	}
+	for _, n := range cleared {
+		nibeLog.Info("Alarm cleared", "system", system, "alarm", n.AlarmNumber, "header", n.Header)
+	}
+
+	state.UpdatesMu.Lock()
+	state.Alarms[system] = active
+	state.UpdatesMu.Unlock()
+
+	// remember the reported alarms so a restart doesn't send them again
+	if len(raised) > 0 || len(cleared) > 0 {
+		ids := make([]int, len(active))
+		for i, n := range active {
+			ids[i] = n.NotificationID
+		}
+		state.SettingsMu.Lock()
+		err := state.updateRuntimeState(func(rs *RuntimeState) {
+			if len(ids) == 0 {
+				delete(rs.Alarms, system)
+			} else {
+				rs.Alarms[system] = ids
+			}
+		})
+		state.SettingsMu.Unlock()
+		if err != nil {
+			nibeLog.Error("Failed to save the reported alarms", "error", err)
+		}
+	}
+
+	state.forwardAlarms(system, raised, cleared)
+}
+
+// HasAlarms reports whether any NIBE system has active alarms. The caller
+// must hold UpdatesMu.
+func (state *SystemState) HasAlarms() bool {
+	for _, alarms := range state.Alarms {
+		if len(alarms) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// AlarmsAPIHandler returns the active alarms of all configured NIBE systems.
+func (state *SystemState) AlarmsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	state.UpdatesMu.RLock()
+	defer state.UpdatesMu.RUnlock()
+	writeJSON(w, http.StatusOK, state.Alarms)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ingmarstein/velux-nibe/nibe"
+)
+
+func TestAlarmWatcher(t *testing.T) {
+	// the IDs of the active alarms returned by the stand-in for NIBE Uplink
+	var active []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var objects []string
+		for _, id := range active {
+			objects = append(objects, fmt.Sprintf(`{"notificationId":%s}`, id))
+		}
+		fmt.Fprintf(w, `{"page":1,"itemsPerPage":100,"numItems":%d,"objects":[%s]}`, len(objects), strings.Join(objects, ","))
+	}))
+	defer upstream.Close()
+	client := nibe.NewClient(upstream.Client())
+	client.BaseURL, _ = url.Parse(upstream.URL + "/")
+
+	type change struct{ active, raised, cleared int }
+	var changes []change
+	watcher := &AlarmWatcher{
+		Client: client,
+		OnChange: func(system int, active, raised, cleared []nibe.Notification) {
+			changes = append(changes, change{len(active), len(raised), len(cleared)})
+		},
+		// alarm 1 was reported before a restart
+		Reported: map[int][]int{12345: {1}},
+	}
+	ctx := context.Background()
+
+	steps := []struct {
+		active []string
+		want   []change
+	}{
+		// only the new alarm is raised, but the first check always reports
+		// the active alarms
+		{[]string{"1", "2"}, []change{{2, 1, 0}}},
+		{[]string{"1", "2"}, nil},
+		{[]string{"2"}, []change{{1, 0, 1}}},
+		{nil, []change{{0, 0, 1}}},
+		{nil, nil},
+	}
+	for i, step := range steps {
+		active = step.active
+		changes = nil
+		watcher.Check(ctx, []int{12345})
+		if fmt.Sprint(changes) != fmt.Sprint(step.want) {
+			t.Errorf("check %d: got changes %v, want %v", i, changes, step.want)
+		}
+	}
+}
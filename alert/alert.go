@@ -0,0 +1,174 @@
+// Package alert sends alerts through webhooks, push services and email.
+//
+// Alerts are identified by a key. An alert is only sent when it starts
+// firing; firing it again has no effect until it has been resolved, at which
+// point a recovery message is sent. Alerts are delivered asynchronously.
+package alert
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Alert struct {
+	// Key identifies the alert for de-duplication, e.g. "sync-failures"
+	Key string `json:"key"`
+	// Short summary
+	Title string `json:"title"`
+	// Details
+	Message string `json:"message"`
+	// Resolved is set for recovery messages
+	Resolved bool `json:"resolved"`
+	// Time the alert started firing or was resolved
+	Time time.Time `json:"time"`
+}
+
+// Notifier delivers alerts to a single destination.
+type Notifier interface {
+	Notify(a Alert) error
+}
+
+// queueSize is the number of alerts which may wait for delivery before new
+// ones are dropped.
+const queueSize = 64
+
+// Manager keeps track of firing alerts and forwards state changes to its
+// notifiers. Alerts are delivered in the background, so slow or unreachable
+// notifiers don't block the caller.
+type Manager struct {
+	mu        sync.Mutex
+	notifiers []Notifier
+	firing    map[string]Alert
+	closed    bool
+
+	queue chan Alert
+	done  chan struct{}
+}
+
+// NewManager returns a Manager which sends alerts to the given notifiers.
+// Call Close to deliver the queued alerts before exiting.
+func NewManager(notifiers ...Notifier) *Manager {
+	m := &Manager{
+		notifiers: notifiers,
+		firing:    make(map[string]Alert),
+		queue:     make(chan Alert, queueSize),
+		done:      make(chan struct{}),
+	}
+	go m.deliver()
+	return m
+}
+
+// Close stops the delivery of new alerts and waits until the queued ones
+// were delivered or ctx is done.
+func (m *Manager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+	m.mu.Unlock()
+
+	select {
+	case <-m.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetNotifiers replaces the notifiers alerts are sent to. Firing alerts are
+// not sent again.
+func (m *Manager) SetNotifiers(notifiers ...Notifier) {
+	m.mu.Lock()
+	m.notifiers = notifiers
+	m.mu.Unlock()
+}
+
+// Fire raises the alert identified by key unless it is already firing.
+func (m *Manager) Fire(key, title, message string) {
+	m.mu.Lock()
+	if _, ok := m.firing[key]; ok {
+		m.mu.Unlock()
+		return
+	}
+	a := Alert{Key: key, Title: title, Message: message, Time: time.Now()}
+	m.firing[key] = a
+	m.mu.Unlock()
+
+	m.send(a)
+}
+
+// Resolve sends a recovery message for the alert identified by key if it is
+// currently firing.
+func (m *Manager) Resolve(key, message string) {
+	m.mu.Lock()
+	a, ok := m.firing[key]
+	if !ok {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.firing, key)
+	m.mu.Unlock()
+
+	a.Title = "Resolved: " + a.Title
+	a.Message = message
+	a.Resolved = true
+	a.Time = time.Now()
+	m.send(a)
+}
+
+// Firing returns the alerts which are currently firing, oldest first.
+func (m *Manager) Firing() []Alert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alerts := make([]Alert, 0, len(m.firing))
+	for _, a := range m.firing {
+		alerts = append(alerts, a)
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Time.Before(alerts[j].Time) })
+	return alerts
+}
+
+// send queues a for delivery.
+func (m *Manager) send(a Alert) {
+	slog.Warn("Alert", "component", "alert", "key", a.Key, "title", a.Title, "message", a.Message, "resolved", a.Resolved)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	select {
+	case m.queue <- a:
+	default:
+		slog.Error("Too many pending alerts, dropping alert", "component", "alert", "key", a.Key)
+	}
+}
+
+// deliver sends the queued alerts to the notifiers until the queue is
+// closed.
+func (m *Manager) deliver() {
+	defer close(m.done)
+	for a := range m.queue {
+		m.mu.Lock()
+		notifiers := m.notifiers
+		m.mu.Unlock()
+		for _, n := range notifiers {
+			if err := n.Notify(a); err != nil {
+				slog.Error("Failed to send alert", "component", "alert", "key", a.Key, "notifier", fmt.Sprintf("%T", n), "error", err)
+			}
+		}
+	}
+}
+
+// text formats an alert as plain text.
+func text(a Alert) string {
+	if a.Message == "" {
+		return a.Title
+	}
+	return fmt.Sprintf("%s\n\n%s", a.Title, a.Message)
+}
@@ -0,0 +1,113 @@
+package alert
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a notifier which records the alerts it receives.
+type recorder struct {
+	mu     sync.Mutex
+	alerts []Alert
+	// block delays Notify until it is closed, if set
+	block chan struct{}
+	err   error
+}
+
+func (r *recorder) Notify(a Alert) error {
+	if r.block != nil {
+		<-r.block
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, a)
+	return r.err
+}
+
+func (r *recorder) titles() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var titles []string
+	for _, a := range r.alerts {
+		titles = append(titles, a.Title)
+	}
+	return titles
+}
+
+func closeManager(t *testing.T, m *Manager) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManager(t *testing.T) {
+	r := &recorder{}
+	// a failing notifier doesn't keep the others from getting the alerts
+	m := NewManager(&recorder{err: errors.New("unreachable")}, r)
+
+	m.Fire("sync-failures", "3 consecutive sync failures", "timeout")
+	// firing again is de-duplicated
+	m.Fire("sync-failures", "4 consecutive sync failures", "timeout")
+	if firing := m.Firing(); len(firing) != 1 || firing[0].Title != "3 consecutive sync failures" {
+		t.Errorf("got firing alerts %+v, want the first one", firing)
+	}
+	// resolving an alert which isn't firing does nothing
+	m.Resolve("no-sync", "Sync succeeded again.")
+	m.Resolve("sync-failures", "Sync succeeded again.")
+	if firing := m.Firing(); len(firing) != 0 {
+		t.Errorf("got firing alerts %+v after resolving, want none", firing)
+	}
+	// after resolving, the alert fires again
+	m.Fire("sync-failures", "3 consecutive sync failures", "timeout")
+	closeManager(t, m)
+
+	want := []string{"3 consecutive sync failures", "Resolved: 3 consecutive sync failures", "3 consecutive sync failures"}
+	got := r.titles()
+	if len(got) != len(want) {
+		t.Fatalf("got alerts %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("alert %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+	if !r.alerts[1].Resolved || r.alerts[1].Message != "Sync succeeded again." {
+		t.Errorf("got recovery message %+v", r.alerts[1])
+	}
+}
+
+func TestManagerDoesNotBlock(t *testing.T) {
+	r := &recorder{block: make(chan struct{})}
+	m := NewManager(r)
+
+	done := make(chan struct{})
+	go func() {
+		// more than the queue and the notifier can take, so some are dropped
+		for i := 0; i < queueSize+2; i++ {
+			m.Fire(strconv.Itoa(i), "alert", "")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Fire blocked on a hanging notifier")
+	}
+
+	close(r.block)
+	closeManager(t, m)
+	// the queued alerts and the one taken by the blocked notifier, if it got
+	// to it in time, are delivered
+	if n := len(r.titles()); n < queueSize || n > queueSize+1 {
+		t.Errorf("got %d alerts, want %d or %d", n, queueSize, queueSize+1)
+	}
+	// alerts raised after closing are dropped
+	m.Fire("late", "alert", "")
+}
@@ -0,0 +1,41 @@
+package alert
+
+import "errors"
+
+// Config describes the destinations alerts are sent to.
+type Config struct {
+	Webhooks []*Webhook `json:"webhooks,omitempty"`
+	Ntfy     []*Ntfy    `json:"ntfy,omitempty"`
+	Gotify   []*Gotify  `json:"gotify,omitempty"`
+	SMTP     []*SMTP    `json:"smtp,omitempty"`
+}
+
+// Notifiers returns the notifiers described by the config.
+func (c *Config) Notifiers() ([]Notifier, error) {
+	var notifiers []Notifier
+	for _, w := range c.Webhooks {
+		if w.URL == "" {
+			return nil, errors.New("webhook: url is required")
+		}
+		notifiers = append(notifiers, w)
+	}
+	for _, n := range c.Ntfy {
+		if n.URL == "" {
+			return nil, errors.New("ntfy: url is required")
+		}
+		notifiers = append(notifiers, n)
+	}
+	for _, g := range c.Gotify {
+		if g.URL == "" || g.Token == "" {
+			return nil, errors.New("gotify: url and token are required")
+		}
+		notifiers = append(notifiers, g)
+	}
+	for _, s := range c.SMTP {
+		if s.Addr == "" || s.From == "" || len(s.To) == 0 {
+			return nil, errors.New("smtp: addr, from and to are required")
+		}
+		notifiers = append(notifiers, s)
+	}
+	return notifiers, nil
+}
@@ -0,0 +1,215 @@
+package alert
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const userAgent = "velux-nibe"
+
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// smtpTimeout limits the time it takes to deliver an email.
+var smtpTimeout = 30 * time.Second
+
+// headerReplacer removes line breaks which would end a mail header.
+var headerReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
+// Webhook posts alerts as JSON to an arbitrary URL.
+type Webhook struct {
+	URL string `json:"url"`
+	// Additional headers, e.g. for authentication
+	Headers map[string]string `json:"headers,omitempty"`
+
+	Client *http.Client `json:"-"`
+}
+
+func (w *Webhook) Notify(a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	return do(w.Client, req)
+}
+
+// Ntfy publishes alerts to a topic of an ntfy server, e.g.
+// https://ntfy.sh/my-topic.
+type Ntfy struct {
+	URL string `json:"url"`
+	// Access token, if the topic is protected
+	Token string `json:"token,omitempty"`
+	// File to read the access token from, instead of Token
+	TokenFile string `json:"token_file,omitempty"`
+
+	Client *http.Client `json:"-"`
+}
+
+func (n *Ntfy) Notify(a Alert) error {
+	req, err := http.NewRequest("POST", n.URL, strings.NewReader(a.Message))
+	if err != nil {
+		return err
+	}
+	// header values must be ASCII
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", headerReplacer.Replace(a.Title)))
+	if a.Resolved {
+		req.Header.Set("Tags", "white_check_mark")
+	} else {
+		req.Header.Set("Tags", "warning")
+		req.Header.Set("Priority", "high")
+	}
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+	return do(n.Client, req)
+}
+
+// Gotify sends alerts as messages to a Gotify server.
+type Gotify struct {
+	// Base URL of the server, e.g. https://gotify.example.com
+	URL string `json:"url"`
+	// Application token
+	Token string `json:"token"`
+	// File to read the application token from, instead of Token
+	TokenFile string `json:"token_file,omitempty"`
+
+	Client *http.Client `json:"-"`
+}
+
+func (g *Gotify) Notify(a Alert) error {
+	u, err := url.JoinPath(g.URL, "message")
+	if err != nil {
+		return err
+	}
+	priority := 8
+	if a.Resolved {
+		priority = 4
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    a.Title,
+		"message":  a.Message,
+		"priority": priority,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.Token)
+	return do(g.Client, req)
+}
+
+func do(client *http.Client, req *http.Request) error {
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// SMTP sends alerts by email.
+type SMTP struct {
+	// Address of the mail server, e.g. smtp.example.com:587
+	Addr string `json:"addr"`
+	// Credentials for PLAIN authentication, if required
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// File to read the password from, instead of Password
+	PasswordFile string   `json:"password_file,omitempty"`
+	From         string   `json:"from"`
+	To           []string `json:"to"`
+}
+
+func (s *SMTP) Notify(a Alert) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "[velux-nibe] "+headerReplacer.Replace(a.Title)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", a.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&msg, "\r\n%s\r\n", strings.ReplaceAll(text(a), "\n", "\r\n"))
+
+	// like smtp.SendMail, but with a deadline so a hung server can't block
+	// the caller
+	conn, err := (&net.Dialer{Timeout: smtpTimeout}).Dial("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(smtpTimeout)); err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
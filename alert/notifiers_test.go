@@ -0,0 +1,215 @@
+package alert
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	var got Alert
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		if r.URL.Path == "/fail" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	a := Alert{Key: "sync-failures", Title: "Sync failed", Message: "3 times in a row", Time: time.Now()}
+	w := &Webhook{URL: srv.URL + "/hook", Headers: map[string]string{"Authorization": "Bearer token"}, Client: srv.Client()}
+	if err := w.Notify(a); err != nil {
+		t.Fatal(err)
+	}
+	if got.Key != a.Key || got.Title != a.Title || got.Message != a.Message {
+		t.Errorf("got %+v, want %+v", got, a)
+	}
+	if auth != "Bearer token" {
+		t.Errorf("got Authorization %q, want the configured header", auth)
+	}
+
+	w.URL = srv.URL + "/fail"
+	err := w.Notify(a)
+	if err == nil || !strings.Contains(err.Error(), "503") || !strings.Contains(err.Error(), "unavailable") {
+		t.Errorf("got error %v, want the status and body", err)
+	}
+}
+
+func TestNtfy(t *testing.T) {
+	var header http.Header
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer srv.Close()
+
+	n := &Ntfy{URL: srv.URL + "/velux-nibe", Token: "tk_token", Client: srv.Client()}
+	if err := n.Notify(Alert{Title: "Raum ist kälter\r\nals erwartet", Message: "16.0 °C"}); err != nil {
+		t.Fatal(err)
+	}
+	if body != "16.0 °C" {
+		t.Errorf("got body %q", body)
+	}
+	title, err := new(mime.WordDecoder).DecodeHeader(header.Get("Title"))
+	if err != nil || title != "Raum ist kälter  als erwartet" {
+		t.Errorf("got Title %q (%q, %v)", header.Get("Title"), title, err)
+	}
+	if header.Get("Priority") != "high" || header.Get("Tags") != "warning" {
+		t.Errorf("got priority %q and tags %q for a firing alert", header.Get("Priority"), header.Get("Tags"))
+	}
+	if header.Get("Authorization") != "Bearer tk_token" {
+		t.Errorf("got Authorization %q", header.Get("Authorization"))
+	}
+
+	if err := n.Notify(Alert{Title: "Resolved: alarm", Resolved: true}); err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("Priority") != "" || header.Get("Tags") != "white_check_mark" {
+		t.Errorf("got priority %q and tags %q for a recovery message", header.Get("Priority"), header.Get("Tags"))
+	}
+}
+
+func TestGotify(t *testing.T) {
+	var path, key string
+	var got struct {
+		Title    string `json:"title"`
+		Message  string `json:"message"`
+		Priority int    `json:"priority"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, key = r.URL.Path, r.Header.Get("X-Gotify-Key")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	g := &Gotify{URL: srv.URL + "/gotify/", Token: "app-token", Client: srv.Client()}
+	if err := g.Notify(Alert{Title: "Sync failed", Message: "timeout"}); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/gotify/message" || key != "app-token" {
+		t.Errorf("got path %q and key %q", path, key)
+	}
+	if got.Title != "Sync failed" || got.Message != "timeout" || got.Priority != 8 {
+		t.Errorf("got %+v", got)
+	}
+
+	if err := g.Notify(Alert{Title: "Resolved: Sync failed", Resolved: true}); err != nil {
+		t.Fatal(err)
+	}
+	if got.Priority != 4 {
+		t.Errorf("got priority %d for a recovery message, want 4", got.Priority)
+	}
+}
+
+// smtpServer accepts a single connection and records the message it
+// receives. If silent is set, it never responds.
+func smtpServer(t *testing.T, silent bool) (addr string, message <-chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	ch := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if silent {
+			io.Copy(io.Discard, conn)
+			return
+		}
+
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd, _, _ := strings.Cut(line, " "); strings.ToUpper(cmd) {
+			case "EHLO":
+				tp.PrintfLine("250-localhost")
+				tp.PrintfLine("250 8BITMIME")
+			case "MAIL", "RCPT":
+				tp.PrintfLine("250 OK")
+			case "DATA":
+				tp.PrintfLine("354 Go ahead")
+				data, err := tp.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				ch <- string(data)
+				tp.PrintfLine("250 OK")
+			case "QUIT":
+				tp.PrintfLine("221 Bye")
+				return
+			default:
+				tp.PrintfLine("502 Not implemented")
+			}
+		}
+	}()
+	return l.Addr().String(), ch
+}
+
+func TestSMTP(t *testing.T) {
+	addr, message := smtpServer(t, false)
+	s := &SMTP{Addr: addr, From: "velux-nibe@example.com", To: []string{"a@example.com", "b@example.com"}}
+	a := Alert{Title: "Température\r\nBcc: evil@example.com", Message: "Line 1\nLine 2", Time: time.Now()}
+	if err := s.Notify(a); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := textproto.NewReader(bufio.NewReader(strings.NewReader(<-message))).ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcc := msg.Get("Bcc"); bcc != "" {
+		t.Errorf("the title injected a Bcc header: %q", bcc)
+	}
+	subject := msg.Get("Subject")
+	if !strings.HasPrefix(subject, "=?utf-8?q?") {
+		t.Errorf("got Subject %q, want it Q-encoded", subject)
+	}
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(subject); err != nil || decoded != "[velux-nibe] Température  Bcc: evil@example.com" {
+		t.Errorf("got Subject %q (%v)", decoded, err)
+	}
+	if to := msg.Get("To"); to != "a@example.com, b@example.com" {
+		t.Errorf("got To %q", to)
+	}
+}
+
+func TestSMTPTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { smtpTimeout = timeout }(smtpTimeout)
+	smtpTimeout = 100 * time.Millisecond
+
+	addr, _ := smtpServer(t, true)
+	s := &SMTP{Addr: addr, From: "velux-nibe@example.com", To: []string{"a@example.com"}}
+	start := time.Now()
+	err := s.Notify(Alert{Title: "Sync failed", Time: time.Now()})
+	if err == nil {
+		t.Fatal("sending to a silent server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %v to give up", elapsed)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ingmarstein/velux-nibe/alert"
+	"github.com/ingmarstein/velux-nibe/nibe"
+)
+
+// AlertSettings defines when alerts are raised and where they are sent.
+type AlertSettings struct {
+	alert.Config
+
+	// ConsecutiveFailures raises an alert after this many failed sync
+	// cycles (0 = disabled).
+	ConsecutiveFailures int `json:"consecutive_failures,omitempty"`
+	// NoSyncMinutes raises an alert if no sync cycle succeeded for this many
+	// minutes (0 = disabled).
+	NoSyncMinutes int `json:"no_sync_minutes,omitempty"`
+	// RoomBelowComfort raises an alert if a room is colder than the minimum
+	// comfort temperature configured in VELUX ACTIVE.
+	RoomBelowComfort bool `json:"room_below_comfort,omitempty"`
+}
+
+// syncHealth tracks the outcome of the recent sync cycles.
+type syncHealth struct {
+	mu          sync.Mutex
+	started     time.Time
+	failures    int
+	lastSuccess time.Time
+	lastError   error
+}
+
+func newAlertManager(settings *AlertSettings) (*alert.Manager, error) {
+	notifiers, err := settings.Notifiers()
+	if err != nil {
+		return nil, err
+	}
+	return alert.NewManager(notifiers...), nil
+}
+
+// syncFinished records the outcome of a sync cycle and evaluates the
+// consecutive failures rule.
+func (state *SystemState) syncFinished(err error) {
+	h := &state.syncHealth
+	h.mu.Lock()
+	h.lastError = err
+	if err == nil {
+		h.failures = 0
+		h.lastSuccess = time.Now()
+	} else {
+		h.failures++
+	}
+	failures := h.failures
+	h.mu.Unlock()
+
+	state.SettingsMu.RLock()
+	threshold := state.Settings.Alerts.ConsecutiveFailures
+	state.SettingsMu.RUnlock()
+
+	if err == nil {
+		state.alerts.Resolve("sync-failures", "Sync succeeded again.")
+		state.alerts.Resolve("no-sync", "Sync succeeded again.")
+	} else if threshold > 0 && failures >= threshold {
+		state.alerts.Fire("sync-failures", fmt.Sprintf("%d consecutive sync failures", failures), err.Error())
+	}
+}
+
+// watchSyncAge raises an alert if no sync cycle succeeded for the configured
+// number of minutes.
+func (state *SystemState) watchSyncAge(ctx context.Context) {
+	h := &state.syncHealth
+	h.mu.Lock()
+	h.started = time.Now()
+	h.mu.Unlock()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		state.SettingsMu.RLock()
+		limit := time.Duration(state.Settings.Alerts.NoSyncMinutes) * time.Minute
+		state.SettingsMu.RUnlock()
+		if limit <= 0 {
+			continue
+		}
+
+		h.mu.Lock()
+		last := h.lastSuccess
+		neverSucceeded := last.IsZero()
+		if neverSucceeded {
+			last = h.started
+		}
+		lastError := h.lastError
+		h.mu.Unlock()
+
+		if time.Since(last) >= limit {
+			msg := fmt.Sprintf("The last successful sync was at %s.", last.Format(time.RFC1123))
+			if neverSucceeded {
+				msg = fmt.Sprintf("No sync succeeded since the start at %s.", last.Format(time.RFC1123))
+			}
+			if lastError != nil {
+				msg += " Last error: " + lastError.Error()
+			}
+			state.alerts.Fire("no-sync", fmt.Sprintf("No successful sync for %s", limit), msg)
+		}
+	}
+}
+
+// checkRoomComfort raises an alert if a room is colder than its minimum
+// comfort temperature. Both temperatures are in deg. Celsius multiplied by
+// 10.
+func (state *SystemState) checkRoomComfort(homeID, roomID, homeName, roomName string, temp, minComfort int) {
+	state.SettingsMu.RLock()
+	enabled := state.Settings.Alerts.RoomBelowComfort
+	state.SettingsMu.RUnlock()
+	if !enabled || minComfort == 0 {
+		return
+	}
+
+	key := "room-cold-" + roomKey(homeID, roomID)
+	if temp < minComfort {
+		state.alerts.Fire(key,
+			fmt.Sprintf("%s is below its comfort temperature", roomName),
+			fmt.Sprintf("Home %s - room %s: %.1f °C (minimum %.1f °C)", homeName, roomName, float64(temp)/10, float64(minComfort)/10))
+	} else {
+		state.alerts.Resolve(key, fmt.Sprintf("Home %s - room %s: %.1f °C", homeName, roomName, float64(temp)/10))
+	}
+}
+
+// forwardAlarms sends NIBE alarms through the configured alert channels.
+func (state *SystemState) forwardAlarms(system int, raised, cleared []nibe.Notification) {
+	for _, n := range raised {
+		state.alerts.Fire(alarmKey(system, n),
+			fmt.Sprintf("NIBE system %d: alarm %d %s", system, n.AlarmNumber, n.Header),
+			fmt.Sprintf("%s (%s, raised %s)", n.Description, n.EquipName, n.CreatedAt))
+	}
+	for _, n := range cleared {
+		state.alerts.Resolve(alarmKey(system, n), "The alarm has been cleared.")
+	}
+}
+
+func alarmKey(system int, n nibe.Notification) string {
+	return fmt.Sprintf("nibe-alarm-%d-%d", system, n.NotificationID)
+}
+
+// FiringAlerts returns the alerts which are currently firing.
+func (state *SystemState) FiringAlerts() []alert.Alert {
+	return state.alerts.Firing()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ingmarstein/velux-nibe/alert"
+)
+
+func firingKeys(state *SystemState) []string {
+	var keys []string
+	for _, a := range state.alerts.Firing() {
+		keys = append(keys, a.Key)
+	}
+	return keys
+}
+
+func TestConsecutiveFailuresRule(t *testing.T) {
+	state := &SystemState{alerts: alert.NewManager()}
+	state.Settings.Alerts.ConsecutiveFailures = 3
+
+	failure := errors.New("401 Unauthorized")
+	for i := 1; i <= 2; i++ {
+		state.syncFinished(failure)
+		if keys := firingKeys(state); len(keys) != 0 {
+			t.Errorf("after %d failures: got firing alerts %v, want none", i, keys)
+		}
+	}
+	state.syncFinished(failure)
+	if keys := firingKeys(state); len(keys) != 1 || keys[0] != "sync-failures" {
+		t.Errorf("after 3 failures: got firing alerts %v, want sync-failures", keys)
+	}
+	state.syncFinished(nil)
+	if keys := firingKeys(state); len(keys) != 0 {
+		t.Errorf("after a success: got firing alerts %v, want none", keys)
+	}
+
+	// disabled rule
+	state.Settings.Alerts.ConsecutiveFailures = 0
+	for i := 0; i < 5; i++ {
+		state.syncFinished(failure)
+	}
+	if keys := firingKeys(state); len(keys) != 0 {
+		t.Errorf("with the rule disabled: got firing alerts %v, want none", keys)
+	}
+}
+
+func TestRoomComfortRule(t *testing.T) {
+	state := &SystemState{alerts: alert.NewManager()}
+	state.Settings.Alerts.RoomBelowComfort = true
+
+	tests := []struct {
+		temp, minComfort int
+		want             bool
+	}{
+		{205, 190, false},
+		{185, 190, true},
+		// still firing, not sent again
+		{180, 190, true},
+		{190, 190, false},
+		// rooms without a minimum comfort temperature
+		{100, 0, false},
+	}
+	for _, tt := range tests {
+		state.checkRoomComfort("home", "room", "Home", "Bedroom", tt.temp, tt.minComfort)
+		keys := firingKeys(state)
+		if firing := len(keys) == 1 && keys[0] == "room-cold-home/room"; firing != tt.want || len(keys) > 1 {
+			t.Errorf("%d with minimum %d: got firing alerts %v, want firing %v", tt.temp, tt.minComfort, keys, tt.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HTTPAuthSettings protects the HTML interface and the API. Authentication is
+// disabled if neither users nor an API token are configured.
+type HTTPAuthSettings struct {
+	// Users maps user names to bcrypt password hashes for HTTP Basic auth,
+	// see the hash-password command
+	Users map[string]string `json:"users,omitempty"`
+	// APIToken grants access to the /api/ endpoints when passed as bearer
+	// token
+	APIToken     string `json:"api_token,omitempty"`
+	APITokenFile string `json:"api_token_file,omitempty"`
+}
+
+func (a *HTTPAuthSettings) enabled() bool {
+	return len(a.Users) > 0 || a.APIToken != ""
+}
+
+// validate returns the problems with the auth settings, without field names.
+func (a *HTTPAuthSettings) validate() []string {
+	users := make([]string, 0, len(a.Users))
+	for user := range a.Users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+
+	var problems []string
+	for _, user := range users {
+		hash := a.Users[user]
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			problems = append(problems, fmt.Sprintf("password of user %q is not a bcrypt hash: %v", user, err))
+		}
+	}
+	return problems
+}
+
+// warnUnprotectedHTML logs a warning if only the API is protected, which
+// leaves the HTML interface and its forms open to everyone.
+func (a *HTTPAuthSettings) warnUnprotectedHTML() {
+	if a.APIToken != "" && len(a.Users) == 0 {
+		httpLog.Warn("Only api_token is set in http_auth, the HTML interface is NOT protected and anyone who can reach it can change the settings; add http_auth.users to require a login")
+	}
+}
+
+// dummyHash is compared against for unknown users, so the response time
+// doesn't reveal which users exist.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("velux-nibe"), bcrypt.DefaultCost)
+	return hash
+})
+
+// checkBasicAuth reports whether the request carries the credentials of one
+// of the users.
+func (a *HTTPAuthSettings) checkBasicAuth(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok || len(a.Users) == 0 {
+		return false
+	}
+	hash, known := a.Users[user]
+	if !known {
+		hash = string(dummyHash())
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	return known && err == nil
+}
+
+// checkBearerToken reports whether the request carries the API token.
+func (a *HTTPAuthSettings) checkBearerToken(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && a.APIToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.APIToken)) == 1
+}
+
+// requireAuth rejects requests without valid credentials if authentication is
+// enabled. The API accepts the bearer token as well as the Basic auth
+// credentials of a user, the HTML interface only the latter.
+func (state *SystemState) requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state.SettingsMu.RLock()
+		auth := state.Settings.HTTPAuth
+		state.SettingsMu.RUnlock()
+
+		isAPI := strings.HasPrefix(r.URL.Path, "/api/")
+		switch {
+		case !auth.enabled():
+		case r.URL.Path == "/healthz" || r.URL.Path == "/readyz":
+			// probes don't carry credentials
+		case isAPI && auth.checkBearerToken(r):
+		case auth.checkBasicAuth(r):
+		case !isAPI && len(auth.Users) == 0:
+			// only the API is protected
+		default:
+			if len(auth.Users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="velux-nibe", charset="UTF-8"`)
+			}
+			if isAPI {
+				writeJSONError(w, http.StatusUnauthorized, fmt.Errorf("authentication required"))
+			} else {
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CSRFToken returns the token which must be included as csrf_token in all
+// forms of the HTML interface. It is generated once per process.
+func (state *SystemState) CSRFToken() string {
+	state.csrfOnce.Do(func() {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			fatal("Failed to generate CSRF token", "error", err)
+		}
+		state.csrfToken = hex.EncodeToString(b)
+	})
+	return state.csrfToken
+}
+
+// checkCSRF rejects POST requests which don't carry the CSRF token or come
+// from a different origin. The API uses PUT, which browsers don't send
+// cross-origin without a CORS preflight.
+func (state *SystemState) checkCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				http.Error(w, "Cross-origin request rejected", http.StatusForbidden)
+				return
+			}
+		}
+		token := r.PostFormValue("csrf_token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(state.CSRFToken())) != 1 {
+			http.Error(w, "Invalid or missing CSRF token, please reload the page", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hashPasswordCommand prints the bcrypt hash of the password read from
+// standard input, for use in http_auth.users.
+func hashPasswordCommand() int {
+	fmt.Fprintln(os.Stderr, "Password:")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(os.Stderr, "Failed to read password: %v\n", err)
+		return 1
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		fmt.Fprintln(os.Stderr, "The password must not be empty")
+		return 2
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to hash password: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(hash))
+	return 0
+}
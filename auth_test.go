@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testAuthSettings(t *testing.T, users bool, token bool) HTTPAuthSettings {
+	t.Helper()
+	var auth HTTPAuthSettings
+	if users {
+		hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth.Users = map[string]string{"admin": string(hash)}
+	}
+	if token {
+		auth.APIToken = "t0ken"
+	}
+	return auth
+}
+
+func TestCheckBasicAuth(t *testing.T) {
+	auth := testAuthSettings(t, true, false)
+	tests := []struct {
+		name           string
+		user, password string
+		basic          bool
+		want           bool
+	}{
+		{"valid", "admin", "secret", true, true},
+		{"wrong password", "admin", "wrong", true, false},
+		{"unknown user", "guest", "secret", true, false},
+		{"no credentials", "", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.basic {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			if got := auth.checkBasicAuth(r); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("admin", "secret")
+	if (&HTTPAuthSettings{}).checkBasicAuth(r) {
+		t.Error("accepted credentials without users")
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	tests := []struct {
+		name         string
+		users, token bool
+		path         string
+		credentials  func(r *http.Request)
+		want         int
+	}{
+		{"disabled", false, false, "/", nil, http.StatusOK},
+		{"disabled api", false, false, "/api/alarms", nil, http.StatusOK},
+		{"html without credentials", true, true, "/", nil, http.StatusUnauthorized},
+		{"html with user", true, true, "/", basicAuth("admin", "secret"), http.StatusOK},
+		{"html with wrong password", true, true, "/", basicAuth("admin", "wrong"), http.StatusUnauthorized},
+		{"html with token", true, true, "/", bearer("t0ken"), http.StatusUnauthorized},
+		{"api without credentials", true, true, "/api/alarms", nil, http.StatusUnauthorized},
+		{"api with token", true, true, "/api/alarms", bearer("t0ken"), http.StatusOK},
+		{"api with wrong token", true, true, "/api/alarms", bearer("wrong"), http.StatusUnauthorized},
+		{"api with user", true, true, "/api/alarms", basicAuth("admin", "secret"), http.StatusOK},
+		{"token only html", false, true, "/", nil, http.StatusOK},
+		{"token only api", false, true, "/api/alarms", nil, http.StatusUnauthorized},
+		{"users only api token", true, false, "/api/alarms", bearer(""), http.StatusUnauthorized},
+		{"healthz", true, true, "/healthz", nil, http.StatusOK},
+		{"readyz", true, true, "/readyz", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &SystemState{Settings: SystemSettings{HTTPAuth: testAuthSettings(t, tt.users, tt.token)}}
+			handler := state.requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.credentials != nil {
+				tt.credentials(r)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got %d, want %d", w.Code, tt.want)
+			}
+			if challenge := w.Header().Get("WWW-Authenticate"); (challenge != "") != (w.Code == http.StatusUnauthorized && tt.users) {
+				t.Errorf("got WWW-Authenticate %q", challenge)
+			}
+		})
+	}
+}
+
+func basicAuth(user, password string) func(r *http.Request) {
+	return func(r *http.Request) { r.SetBasicAuth(user, password) }
+}
+
+func bearer(token string) func(r *http.Request) {
+	return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+}
+
+func TestCheckCSRF(t *testing.T) {
+	state := &SystemState{}
+	handler := state.checkCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		origin string
+		want   int
+	}{
+		{"get", http.MethodGet, "", "", http.StatusOK},
+		{"put", http.MethodPut, "", "", http.StatusOK},
+		{"post with token", http.MethodPost, state.CSRFToken(), "", http.StatusOK},
+		{"post with token and origin", http.MethodPost, state.CSRFToken(), "http://example.com", http.StatusOK},
+		{"post without token", http.MethodPost, "", "", http.StatusForbidden},
+		{"post with wrong token", http.MethodPost, "wrong", "", http.StatusForbidden},
+		{"cross-origin post", http.MethodPost, state.CSRFToken(), "http://evil.example.org", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.token != "" {
+				form.Set("csrf_token", tt.token)
+			}
+			r := httptest.NewRequest(tt.method, "http://example.com/away", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("got %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
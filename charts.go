@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ingmarstein/velux-nibe/history"
+)
+
+// chartWindow is a time range selectable on the charts page.
+type chartWindow struct {
+	Name     string
+	Duration time.Duration
+	// Interval readings are averaged over
+	Interval time.Duration
+}
+
+var chartWindows = []chartWindow{
+	{Name: "6h", Duration: 6 * time.Hour, Interval: 5 * time.Minute},
+	{Name: "24h", Duration: 24 * time.Hour, Interval: 15 * time.Minute},
+	{Name: "7d", Duration: 7 * 24 * time.Hour, Interval: time.Hour},
+}
+
+const (
+	chartWidth   = 640
+	chartHeight  = 200
+	chartMarginL = 48
+	chartMarginR = 12
+	chartMarginT = 24
+	chartMarginB = 24
+)
+
+type chartPoint struct {
+	T time.Time
+	V float64
+}
+
+type chartSeries struct {
+	Name   string
+	Color  string
+	Points []chartPoint
+}
+
+// renderChart renders a line chart of the given series as inline SVG. Lines
+// are interrupted where no readings were recorded for more than two
+// intervals.
+func renderChart(title, unit string, from, to time.Time, interval time.Duration, series []chartSeries) template.HTML {
+	minV, maxV := math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, p := range s.Points {
+			minV = math.Min(minV, p.V)
+			maxV = math.Max(maxV, p.V)
+		}
+	}
+	if math.IsInf(minV, 0) {
+		return ""
+	}
+	// leave some room above and below the lines
+	padding := math.Max((maxV-minV)*0.1, 0.5)
+	minV, maxV = math.Floor(minV-padding), math.Ceil(maxV+padding)
+
+	plotW := float64(chartWidth - chartMarginL - chartMarginR)
+	plotH := float64(chartHeight - chartMarginT - chartMarginB)
+	x := func(t time.Time) float64 {
+		return chartMarginL + plotW*float64(t.Sub(from))/float64(to.Sub(from))
+	}
+	y := func(v float64) float64 {
+		return chartMarginT + plotH*(1-(v-minV)/(maxV-minV))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`, chartWidth, chartHeight, chartWidth, chartHeight)
+	fmt.Fprintf(&b, `<text x="%d" y="14" font-weight="bold">%s</text>`, chartMarginL, template.HTMLEscapeString(title))
+
+	// horizontal grid lines and labels
+	const gridLines = 4
+	for i := 0; i <= gridLines; i++ {
+		v := minV + (maxV-minV)*float64(i)/gridLines
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`, chartMarginL, y(v), chartWidth-chartMarginR, y(v))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end">%.1f%s</text>`, chartMarginL-4, y(v)+4, v, template.HTMLEscapeString(unit))
+	}
+
+	// time labels at the start, middle and end
+	layout := "15:04"
+	if to.Sub(from) > 24*time.Hour {
+		layout = "Jan 02"
+	}
+	for i, anchor := range []string{"start", "middle", "end"} {
+		t := from.Add(to.Sub(from) * time.Duration(i) / 2)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="%s">%s</text>`, x(t), chartHeight-6, anchor, t.Local().Format(layout))
+	}
+
+	// legend
+	legendX := chartWidth - chartMarginR
+	for i := len(series) - 1; i >= 0; i-- {
+		s := series[i]
+		fmt.Fprintf(&b, `<text x="%d" y="14" text-anchor="end" fill="%s">%s</text>`, legendX, s.Color, template.HTMLEscapeString(s.Name))
+		legendX -= 8 * (len(s.Name) + 2)
+	}
+
+	for _, s := range series {
+		var segment []string
+		flush := func() {
+			if len(segment) > 0 {
+				fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5" points="%s"/>`, s.Color, strings.Join(segment, " "))
+			}
+			segment = segment[:0]
+		}
+		for i, p := range s.Points {
+			if i > 0 && p.T.Sub(s.Points[i-1].T) > 2*interval {
+				flush()
+			}
+			segment = append(segment, fmt.Sprintf("%.1f,%.1f", x(p.T), y(p.V)))
+		}
+		flush()
+	}
+
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// RoomCharts holds the rendered charts of a single room.
+type RoomCharts struct {
+	Home   string
+	Room   string
+	Charts []template.HTML
+}
+
+// roomCharts groups the readings by room and renders temperature, humidity
+// and CO2 charts for each of them. Humidity and CO2 charts are omitted for
+// rooms without such sensors.
+func roomCharts(readings []history.Reading, from, to time.Time, interval time.Duration) []RoomCharts {
+	type room struct {
+		home, name          string
+		actual, target      []chartPoint
+		humidity, co2       []chartPoint
+		hasHumidity, hasCO2 bool
+	}
+	var order []string
+	rooms := make(map[string]*room)
+	for _, r := range readings {
+		if r.IsTelemetry() {
+			continue
+		}
+		key := roomKey(r.HomeID, r.RoomID)
+		rm, ok := rooms[key]
+		if !ok {
+			rm = &room{}
+			rooms[key] = rm
+			order = append(order, key)
+		}
+		// use the most recent names
+		rm.home, rm.name = r.Home, r.Room
+		rm.actual = append(rm.actual, chartPoint{r.Time, float64(r.Temperature) / 10})
+		rm.target = append(rm.target, chartPoint{r.Time, float64(r.Target) / 10})
+		rm.humidity = append(rm.humidity, chartPoint{r.Time, float64(r.Humidity)})
+		rm.co2 = append(rm.co2, chartPoint{r.Time, float64(r.CO2)})
+		rm.hasHumidity = rm.hasHumidity || r.Humidity != 0
+		rm.hasCO2 = rm.hasCO2 || r.CO2 != 0
+	}
+
+	result := make([]RoomCharts, 0, len(order))
+	for _, key := range order {
+		rm := rooms[key]
+		rc := RoomCharts{Home: rm.home, Room: rm.name}
+		rc.Charts = append(rc.Charts, renderChart("Temperature", "°", from, to, interval, []chartSeries{
+			{Name: "actual", Color: "#d62728", Points: rm.actual},
+			{Name: "target", Color: "#1f77b4", Points: rm.target},
+		}))
+		if rm.hasHumidity {
+			rc.Charts = append(rc.Charts, renderChart("Humidity", "%", from, to, interval, []chartSeries{
+				{Name: "humidity", Color: "#2ca02c", Points: rm.humidity},
+			}))
+		}
+		if rm.hasCO2 {
+			rc.Charts = append(rc.Charts, renderChart("CO2", "", from, to, interval, []chartSeries{
+				{Name: "ppm", Color: "#9467bd", Points: rm.co2},
+			}))
+		}
+		result = append(result, rc)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].Home != result[j].Home {
+			return result[i].Home < result[j].Home
+		}
+		return result[i].Room < result[j].Room
+	})
+	return result
+}
+
+// chartColors are assigned to the series of heat pump charts in turn.
+var chartColors = []string{"#d62728", "#1f77b4", "#2ca02c", "#9467bd", "#ff7f0e", "#8c564b", "#e377c2", "#17becf"}
+
+// SystemCharts holds the rendered telemetry charts of a NIBE system.
+type SystemCharts struct {
+	System int
+	Charts []template.HTML
+}
+
+// systemCharts groups the telemetry readings by system and renders a chart of
+// the parameters of each unit and one of the active components, which are 1
+// while a component is running.
+func systemCharts(readings []history.Reading, from, to time.Time, interval time.Duration) []SystemCharts {
+	var order []int
+	systems := make(map[int][]history.Reading)
+	for _, r := range readings {
+		// skip readings which failed entirely
+		if !r.IsTelemetry() || r.Result != "" && len(r.Parameters) == 0 {
+			continue
+		}
+		if _, ok := systems[r.System]; !ok {
+			order = append(order, r.System)
+		}
+		systems[r.System] = append(systems[r.System], r)
+	}
+
+	result := make([]SystemCharts, 0, len(order))
+	for _, id := range order {
+		var units, components []string
+		parameters := make(map[string][]chartSeries)
+		for _, r := range systems[id] {
+		params:
+			for _, p := range r.Parameters {
+				series, ok := parameters[p.Unit]
+				if !ok {
+					units = append(units, p.Unit)
+				}
+				point := chartPoint{r.Time, p.Value}
+				for i := range series {
+					if series[i].Name == p.Name {
+						series[i].Points = append(series[i].Points, point)
+						continue params
+					}
+				}
+				parameters[p.Unit] = append(series, chartSeries{
+					Name:   p.Name,
+					Color:  chartColors[len(series)%len(chartColors)],
+					Points: []chartPoint{point},
+				})
+			}
+			for _, c := range r.Components {
+				if !slices.Contains(components, c) {
+					components = append(components, c)
+				}
+			}
+		}
+
+		sc := SystemCharts{System: id}
+		for _, unit := range units {
+			sc.Charts = append(sc.Charts, renderChart("Parameters", unit, from, to, interval, parameters[unit]))
+		}
+		if len(components) > 0 {
+			series := make([]chartSeries, len(components))
+			for i, c := range components {
+				series[i] = chartSeries{Name: c, Color: chartColors[i%len(chartColors)]}
+				for _, r := range systems[id] {
+					v := 0.0
+					if slices.Contains(r.Components, c) {
+						v = 1
+					}
+					series[i].Points = append(series[i].Points, chartPoint{r.Time, v})
+				}
+			}
+			sc.Charts = append(sc.Charts, renderChart("Active components", "", from, to, interval, series))
+		}
+		result = append(result, sc)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].System < result[j].System })
+	return result
+}
+
+var chartsTemplate = template.Must(template.New("charts").Parse(`
+<!DOCTYPE html>
+<html>
+	<head>
+		<title>Velux-Nibe - Charts</title>
+	</head>
+	<body>
+		<h1>Charts</h1>
+		<p>
+			<a href="/">Back</a> |
+			{{range $.Windows}}
+			{{if eq .Name $.Window.Name}}<strong>{{.Name}}</strong>{{else}}<a href="?window={{.Name}}">{{.Name}}</a>{{end}}
+			{{end}}
+		</p>
+		{{range .Rooms}}
+		<h2>{{.Home}} - {{.Room}}</h2>
+		{{range .Charts}}<div>{{.}}</div>{{end}}
+		{{else}}
+		<p>No readings recorded in the last {{.Window.Name}}.</p>
+		{{end}}
+		{{range .Systems}}
+		<h2>Heat pump - System {{.System}}</h2>
+		{{range .Charts}}<div>{{.}}</div>{{end}}
+		{{end}}
+	</body>
+</html>
+`))
+
+// ChartsHandler renders charts of the room readings and the heat pump
+// telemetry of the selected window.
+func (state *SystemState) ChartsHandler(w http.ResponseWriter, r *http.Request) {
+	if state.history == nil {
+		http.Error(w, "History is disabled", http.StatusNotFound)
+		return
+	}
+
+	window := chartWindows[1]
+	for _, cw := range chartWindows {
+		if cw.Name == r.URL.Query().Get("window") {
+			window = cw
+		}
+	}
+
+	to := time.Now()
+	from := to.Add(-window.Duration)
+	readings, err := state.history.Query(history.Query{From: from, To: to, Interval: window.Interval})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read history: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		Window  chartWindow
+		Windows []chartWindow
+		Rooms   []RoomCharts
+		Systems []SystemCharts
+	}{window, chartWindows, roomCharts(readings, from, to, window.Interval), systemCharts(readings, from, to, window.Interval)}
+	if err := chartsTemplate.Execute(w, data); err != nil {
+		httpLog.Error("Failed to render charts", "error", err)
+	}
+}
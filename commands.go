@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ingmarstein/velux-nibe/nibe"
+	"github.com/ingmarstein/velux-nibe/velux"
+)
+
+const commandUsage = `Commands:
+  config check        validate the settings from the config file, flags and environment
+  healthcheck [-live] [-url URL]
+                      query /readyz (or /healthz) of the running instance, for Docker HEALTHCHECK
+  hash-password       read a password from standard input and print its bcrypt hash for http_auth.users
+  export [-format csv|ndjson] [-from -24h] [-to TIME] [-room ROOM] [-interval 1h]
+                      export the recorded room readings
+  nibe systems        list the NIBE systems, units and climate systems of the account
+  nibe thermostats    list the smart home thermostats known to NIBE Uplink
+  nibe set-parameter SYSTEM PARAMETER VALUE
+                      change the raw value of a parameter listed in nibe_writable_parameters
+`
+
+// runCommand executes the subcommand given in args and returns the exit code.
+func runCommand(ctx context.Context, args []string) int {
+	switch args[0] {
+	case "export":
+		return exportCommand(args[1:])
+	case "healthcheck":
+		return healthcheckCommand(args[1:])
+	case "hash-password":
+		return hashPasswordCommand()
+	}
+	if len(args) >= 2 {
+		switch args[0] + " " + args[1] {
+		case "config check":
+			return configCheckCommand()
+		case "nibe systems":
+			return nibeSystemsCommand(ctx)
+		case "nibe thermostats":
+			return nibeThermostatsCommand(ctx)
+		case "nibe set-parameter":
+			return nibeSetParameterCommand(ctx, args[2:])
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n%s", strings.Join(args, " "), commandUsage)
+	return 2
+}
+
+// configCheckCommand validates the settings and lists all problems found.
+func configCheckCommand() int {
+	settings, err := readSettings()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := settings.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid settings:\n%s\n", indent(err.Error()))
+		return 1
+	}
+	fmt.Println("Settings are valid.")
+	return 0
+}
+
+// indent indents each line of s by two spaces.
+func indent(s string) string {
+	return "  " + strings.ReplaceAll(s, "\n", "\n  ")
+}
+
+// newNIBEClient creates a NIBE client from the given settings.
+func newNIBEClient(settings *SystemSettings) *nibe.Client {
+	client := nibe.NewClientWithAuth(settings.ClientID, settings.ClientSecret, settings.CallbackURL, settings.TokenFile, []string{nibe.ScopeWrite})
+	client.Verbose.Store(settings.Verbose)
+	client.Logger = nibeLog
+	return client
+}
+
+func requireNIBECredentials(settings *SystemSettings) bool {
+	if settings.ClientID == "" || settings.ClientSecret == "" || settings.CallbackURL == "" {
+		fmt.Fprintln(os.Stderr, "NIBE client ID, client secret and callback URL are required")
+		return false
+	}
+	return true
+}
+
+// nibeSystemsCommand prints the systems the NIBE account has access to along
+// with their units and climate systems.
+func nibeSystemsCommand(ctx context.Context) int {
+	settings := loadSettings()
+	if !requireNIBECredentials(&settings) {
+		return 2
+	}
+	client := newNIBEClient(&settings)
+
+	systems, err := client.GetAllSystems(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list NIBE systems: %v\n", err)
+		return 1
+	}
+	if len(systems) == 0 {
+		fmt.Println("No NIBE systems found.")
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, system := range systems {
+		fmt.Fprintf(w, "System %d\t%s\n", system.SystemID, system.Name)
+		fmt.Fprintf(w, "  Product\t%s\n", system.ProductName)
+		fmt.Fprintf(w, "  Serial number\t%s\n", system.SerialNumber)
+		fmt.Fprintf(w, "  Connection\t%s\n", system.ConnectionStatus)
+		fmt.Fprintf(w, "  Security level\t%s\n", system.SecurityLevel)
+
+		units, err := client.GetSystemUnits(ctx, nibe.GetSystemUnitsRequest{SystemID: system.SystemID})
+		if err != nil {
+			fmt.Fprintf(w, "  Units\terror: %v\n", err)
+		}
+		for _, unit := range units {
+			fmt.Fprintf(w, "  Unit %d\t%s (%s)\n", unit.SystemUnitID, unit.Name, unit.Product)
+		}
+
+		climateSystems, err := climateSystems(ctx, client, system.SystemID)
+		if err != nil {
+			fmt.Fprintf(w, "  Climate systems\terror: %v\n", err)
+		}
+		for _, cs := range climateSystems {
+			fmt.Fprintf(w, "  Climate system %d\t%s\n", cs.ID, cs.Name)
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+	return 0
+}
+
+type climateSystem struct {
+	ID   int
+	Name string
+}
+
+// climateSystems derives the climate systems of a NIBE system from the
+// service info categories of its main unit, which are named SYSTEM_1,
+// SYSTEM_2, etc.
+func climateSystems(ctx context.Context, client *nibe.Client, systemID int) ([]climateSystem, error) {
+	categories, err := client.GetServiceInfoCategories(ctx, nibe.GetServiceInfoCategoriesRequest{SystemID: systemID})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []climateSystem
+	for _, category := range categories {
+		id, ok := strings.CutPrefix(category.CategoryID, "SYSTEM_")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			continue
+		}
+		result = append(result, climateSystem{ID: n, Name: category.Name})
+	}
+	return result, nil
+}
+
+// nibeThermostatsCommand prints the thermostats known to NIBE Uplink for the
+// configured systems. Like the running service, it marks thermostats created
+// by velux-nibe as stale if their Velux room no longer exists.
+func nibeThermostatsCommand(ctx context.Context) int {
+	settings := loadSettings()
+	if !requireNIBECredentials(&settings) {
+		return 2
+	}
+	if settings.Username == "" || settings.Password == "" {
+		fmt.Fprintln(os.Stderr, "Velux user name and password are required to find stale thermostats")
+		return 2
+	}
+	systems := settings.configuredSystems()
+	if len(systems) == 0 {
+		fmt.Fprintln(os.Stderr, "No NIBE system configured")
+		return 2
+	}
+	externalIDs, err := LoadExternalIDs(settings.IDMapFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load NIBE thermostat IDs: %v\n", err)
+		return 1
+	}
+
+	hc, err := velux.NewAuthClient(settings.Username, settings.Password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to log in to Velux: %v\n", err)
+		return 1
+	}
+	veluxClient := velux.NewClient(hc)
+	veluxClient.Verbose.Store(settings.Verbose)
+	veluxClient.Logger = veluxLog
+	homeData, err := veluxClient.GetHomesData(ctx, velux.GetHomesDataRequest{GatewayTypes: []string{velux.Bridge}})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list Velux homes: %v\n", err)
+		return 1
+	}
+
+	client := newNIBEClient(&settings)
+	thermostats, err := listThermostats(ctx, client, systems, externalIDs, currentRooms(homeData, &settings, externalIDs))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list NIBE thermostats: %v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SYSTEM\tID\tNAME\tCURRENT\tTARGET\tLAST UPDATE\tSTATUS")
+	for _, t := range thermostats {
+		status := "active"
+		if t.Stale {
+			status = "stale"
+		} else if !t.Managed {
+			status = "other source"
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\t%s\n", t.System, t.ExternalID, t.Name, formatTenths(t.CurrentTemperature), formatTenths(t.TargetTemperature), t.LastUpdate, status)
+	}
+	w.Flush()
+	return 0
+}
+
+// nibeSetParameterCommand changes a single NIBE parameter.
+func nibeSetParameterCommand(ctx context.Context, args []string) int {
+	if len(args) != 3 {
+		fmt.Fprintf(os.Stderr, "Usage: nibe set-parameter SYSTEM PARAMETER VALUE\n")
+		return 2
+	}
+	var values [3]int
+	for i, arg := range args {
+		v, err := strconv.Atoi(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid argument %q: %v\n", arg, err)
+			return 2
+		}
+		values[i] = v
+	}
+	system, parameter, value := values[0], values[1], values[2]
+
+	settings := loadSettings()
+	if !requireNIBECredentials(&settings) {
+		return 2
+	}
+	client := newNIBEClient(&settings)
+
+	results, err := setParameters(ctx, client, &settings, system, map[nibe.ParameterID]int{nibe.ParameterID(parameter): value})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to set parameter: %v\n", err)
+		return 1
+	}
+	exitCode := 0
+	for _, result := range results {
+		fmt.Printf("%d\t%s\t%s\t%s\n", result.Parameter.ParameterID, result.Parameter.Title, result.Parameter.DisplayValue, result.Status)
+		if result.Status.Failed() {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ingmarstein/velux-nibe/nibe"
+)
+
+func lenientParseInt(s string) int {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+var username = flag.String("velux-user", os.Getenv("VELUX_USERNAME"), "Velux user name")
+var password = flag.String("velux-password", os.Getenv("VELUX_PASSWORD"), "Velux password")
+var passwordFile = flag.String("velux-password-file", os.Getenv("VELUX_PASSWORD_FILE"), "File containing the Velux password")
+var clientID = flag.String("nibe-client-id", os.Getenv("NIBE_CLIENT_ID"), "NIBE Uplink client ID")
+var clientSecret = flag.String("nibe-client-secret", os.Getenv("NIBE_CLIENT_SECRET"), "NIBE Uplink client secret")
+var clientSecretFile = flag.String("nibe-client-secret-file", os.Getenv("NIBE_CLIENT_SECRET_FILE"), "File containing the NIBE Uplink client secret")
+var callbackURL = flag.String("nibe-callback", os.Getenv("NIBE_CALLBACK_URL"), "NIBE Uplink callback URL")
+var system = flag.Int("nibe-system", lenientParseInt(os.Getenv("NIBE_SYSTEM_ID")), "NIBE system ID")
+var nibeTokenFile = flag.String("nibe-token", os.Getenv("NIBE_TOKEN"), "File name to store the NIBE token")
+var idMapFile = flag.String("nibe-id-map", os.Getenv("NIBE_ID_MAP"), "File name to store the mapping of Velux rooms to NIBE thermostat IDs")
+var verbose = flag.Bool("verbose", false, "Verbose mode: log all API requests and responses at debug level")
+var logLevelFlag = flag.String("log-level", os.Getenv("LOG_LEVEL"), "Log level: debug, info, warn or error (default: info)")
+var logFormat = flag.String("log-format", os.Getenv("LOG_FORMAT"), "Log format: text or json (default: text)")
+var targetTemp = flag.Int("targetTemp", 210, "Target temperature in celsius, multiplied by ten")
+var pollInterval = flag.Int("interval", 60, "Polling interval in seconds")
+var httpPort = flag.Int("http-port", lenientParseInt(os.Getenv("HTTP_PORT")), "Port for HTTP interface (0 = disabled)")
+var tlsCertFile = flag.String("tls-cert", os.Getenv("TLS_CERT_FILE"), "TLS certificate file for the HTTP interface")
+var tlsKeyFile = flag.String("tls-key", os.Getenv("TLS_KEY_FILE"), "TLS private key file for the HTTP interface")
+var tlsSelfSigned = flag.Bool("tls-self-signed", false, "Serve the HTTP interface with a generated self-signed certificate")
+var stateFile = flag.String("state-file", os.Getenv("STATE_FILE"), "File name to store the settings changed in the HTML interface")
+var historyDir = flag.String("history-dir", os.Getenv("HISTORY_DIR"), "Directory to store the history of room readings")
+var configFile = flag.String("conf", os.Getenv("CONFIG_FILE"), "Config file (JSON, YAML or TOML)")
+var configFileFormat = flag.String("conf-format", "", "Config file format: json, yaml or toml (default: by file extension)")
+
+// NIBESystemSettings holds the settings specific to a single NIBE system.
+type NIBESystemSettings struct {
+	// ClimateSystems affected by the thermostats of this system (default: 1)
+	ClimateSystems []int `json:"climate_systems,omitempty"`
+	// TargetTemperature overrides the global target temperature if non-zero
+	TargetTemperature int `json:"target_temperature,omitempty"`
+}
+
+type SystemSettings struct {
+	Username          string `json:"velux_user"`
+	Password          string `json:"velux_password"`
+	ClientID          string `json:"nibe_client_id"`
+	ClientSecret      string `json:"nibe_client_secret"`
+	CallbackURL       string `json:"nibe_callback"`
+	System            int    `json:"nibe_system"`
+	TokenFile         string `json:"nibe_token"`
+	IDMapFile         string `json:"nibe_id_map,omitempty"`
+	PollInterval      int    `json:"interval"`
+	Verbose           bool   `json:"verbose"`
+	TargetTemperature int    `json:"target_temperature"`
+	HTTPPort          int    `json:"http_port,omitempty"`
+
+	// PasswordFile and ClientSecretFile name files containing the Velux
+	// password and NIBE client secret, e.g. Docker or Kubernetes secrets.
+	PasswordFile     string `json:"velux_password_file,omitempty"`
+	ClientSecretFile string `json:"nibe_client_secret_file,omitempty"`
+
+	// Homes maps Velux home IDs to NIBE system IDs. Homes which are not
+	// listed are mapped to System, if set.
+	Homes map[string]int `json:"homes,omitempty"`
+	// Systems holds per-system settings, keyed by NIBE system ID.
+	Systems map[int]NIBESystemSettings `json:"nibe_systems,omitempty"`
+	// WritableParameters lists the IDs of the NIBE parameters which may be
+	// changed through velux-nibe. No parameters may be changed by default.
+	WritableParameters []nibe.ParameterID `json:"nibe_writable_parameters,omitempty"`
+	// TelemetryParameters lists the NIBE parameters shown in the heat pump
+	// section of the HTML interface.
+	TelemetryParameters []nibe.ParameterID `json:"telemetry_parameters,omitempty"`
+	// TelemetryInterval in seconds (0 = poll interval, negative = disabled)
+	TelemetryInterval int `json:"telemetry_interval,omitempty"`
+	// Alerts defines when and where alerts are sent.
+	Alerts AlertSettings `json:"alerts"`
+	// AwayTargetTemperature is sent for all rooms in away mode (default: 160)
+	AwayTargetTemperature int `json:"away_target_temperature,omitempty"`
+	// StateFile stores the settings changed in the HTML interface (default:
+	// "velux-nibe-state.json" next to the config file)
+	StateFile string `json:"state_file,omitempty"`
+	// TLS enables HTTPS for the HTML interface and the API.
+	TLS TLSSettings `json:"tls"`
+	// HTTPAuth protects the HTML interface and the API.
+	HTTPAuth HTTPAuthSettings `json:"http_auth"`
+	// LogLevel is debug, info, warn or error (default: info, or debug in
+	// verbose mode)
+	LogLevel string `json:"log_level,omitempty"`
+	// LogFormat is text or json (default: text)
+	LogFormat string `json:"log_format,omitempty"`
+	// HistoryDir holds the history of room readings (default: "history"
+	// next to the config file)
+	HistoryDir string `json:"history_dir,omitempty"`
+	// HistoryRetention in days (0 = 30 days, negative = history disabled)
+	HistoryRetention int `json:"history_retention,omitempty"`
+}
+
+// homeSystem returns the NIBE system the given Velux home is mapped to.
+func (s *SystemSettings) homeSystem(homeID string) (int, bool) {
+	if system, ok := s.Homes[homeID]; ok {
+		return system, system != 0
+	}
+	return s.System, s.System != 0
+}
+
+// targetTemperature returns the target temperature for the given NIBE system.
+func (s *SystemSettings) targetTemperature(system int) int {
+	if t := s.Systems[system].TargetTemperature; t != 0 {
+		return t
+	}
+	return s.TargetTemperature
+}
+
+// climateSystems returns the climate systems affected by thermostats of the
+// given NIBE system.
+func (s *SystemSettings) climateSystems(system int) []int {
+	if cs := s.Systems[system].ClimateSystems; len(cs) > 0 {
+		return cs
+	}
+	return []int{1}
+}
+
+// configuredSystems returns the IDs of all NIBE systems referenced in the
+// settings.
+func (s *SystemSettings) configuredSystems() []int {
+	seen := make(map[int]bool)
+	var systems []int
+	add := func(system int) {
+		if system != 0 && !seen[system] {
+			seen[system] = true
+			systems = append(systems, system)
+		}
+	}
+	add(s.System)
+	for _, system := range s.Homes {
+		add(system)
+	}
+	sort.Ints(systems)
+	return systems
+}
+
+// loadSettings reads the config file, if any, and applies the settings passed
+// as command line flags or environment variables on top of it.
+func loadSettings() SystemSettings {
+	settings, err := readSettings()
+	if err != nil {
+		fatal("Failed to read settings", "error", err)
+	}
+	return settings
+}
+
+// readSettings is like loadSettings, but returns an error if the config file
+// can't be read.
+func readSettings() (SystemSettings, error) {
+	var flagsPassed = make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		flagsPassed[f.Name] = true
+	})
+
+	var settings SystemSettings
+
+	if *configFile != "" {
+		format, err := configFormat(*configFile, *configFileFormat)
+		if err != nil {
+			return settings, err
+		}
+		f, err := os.Open(*configFile)
+		if err != nil {
+			return settings, fmt.Errorf("failed to open config file: %w", err)
+		}
+		err = decodeConfig(f, format, &settings)
+		f.Close()
+		if err != nil {
+			return settings, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
+
+	// command line flags override settings from the config file
+	if *username != "" {
+		settings.Username = *username
+	}
+	if *password != "" {
+		settings.Password = *password
+		settings.PasswordFile = ""
+	}
+	if *passwordFile != "" {
+		settings.PasswordFile = *passwordFile
+		settings.Password = ""
+	}
+	if *clientID != "" {
+		settings.ClientID = *clientID
+	}
+	if *clientSecret != "" {
+		settings.ClientSecret = *clientSecret
+		settings.ClientSecretFile = ""
+	}
+	if *clientSecretFile != "" {
+		settings.ClientSecretFile = *clientSecretFile
+		settings.ClientSecret = ""
+	}
+	if *callbackURL != "" {
+		settings.CallbackURL = *callbackURL
+	}
+	if *nibeTokenFile != "" {
+		settings.TokenFile = *nibeTokenFile
+	}
+	if *idMapFile != "" {
+		settings.IDMapFile = *idMapFile
+	}
+	if *system != 0 {
+		settings.System = *system
+	}
+	if *tlsCertFile != "" {
+		settings.TLS.CertFile = *tlsCertFile
+	}
+	if *tlsKeyFile != "" {
+		settings.TLS.KeyFile = *tlsKeyFile
+	}
+	if *tlsSelfSigned {
+		settings.TLS.SelfSigned = true
+	}
+	if *stateFile != "" {
+		settings.StateFile = *stateFile
+	}
+	if *historyDir != "" {
+		settings.HistoryDir = *historyDir
+	}
+	if flagsPassed["interval"] {
+		settings.PollInterval = *pollInterval
+	}
+	if *verbose {
+		settings.Verbose = true
+	}
+	if *logLevelFlag != "" {
+		settings.LogLevel = *logLevelFlag
+	}
+	if *logFormat != "" {
+		settings.LogFormat = *logFormat
+	}
+	if flagsPassed["targetTemp"] {
+		settings.TargetTemperature = *targetTemp
+	}
+	if *httpPort != 0 {
+		settings.HTTPPort = *httpPort
+	}
+
+	if err := settings.readSecretFiles(); err != nil {
+		return settings, err
+	}
+
+	if settings.TokenFile == "" {
+		settings.TokenFile = "nibe-token.json"
+	}
+	if settings.IDMapFile == "" {
+		// keep the mapping next to the config file, if there is one
+		settings.IDMapFile = filepath.Join(filepath.Dir(*configFile), "velux-nibe-ids.json")
+	}
+
+	if settings.TLS.SelfSigned {
+		if settings.TLS.CertFile == "" {
+			settings.TLS.CertFile = filepath.Join(filepath.Dir(*configFile), "velux-nibe-cert.pem")
+		}
+		if settings.TLS.KeyFile == "" {
+			settings.TLS.KeyFile = filepath.Join(filepath.Dir(*configFile), "velux-nibe-key.pem")
+		}
+	}
+	if settings.StateFile == "" {
+		settings.StateFile = filepath.Join(filepath.Dir(*configFile), "velux-nibe-state.json")
+	}
+	if settings.HistoryDir == "" {
+		settings.HistoryDir = filepath.Join(filepath.Dir(*configFile), "history")
+	}
+
+	return settings, nil
+}
+
+// Valid range of target temperatures, in deg. Celsius multiplied by 10.
+const (
+	minTargetTemperature = 100
+	maxTargetTemperature = 300
+)
+
+// Validate checks that the settings are complete and usable. It returns all
+// problems at once, each prefixed with the name of the offending setting.
+func (s *SystemSettings) Validate() error {
+	var errs []error
+	problem := func(field, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if s.Username == "" {
+		problem("velux_user", "is required (flag -velux-user or $VELUX_USERNAME)")
+	}
+	if s.Password == "" {
+		problem("velux_password", "is required (velux_password_file, flag -velux-password or $VELUX_PASSWORD)")
+	}
+	if s.ClientID == "" {
+		problem("nibe_client_id", "is required (flag -nibe-client-id or $NIBE_CLIENT_ID)")
+	}
+	if s.ClientSecret == "" {
+		problem("nibe_client_secret", "is required (nibe_client_secret_file, flag -nibe-client-secret or $NIBE_CLIENT_SECRET)")
+	}
+	if s.CallbackURL == "" {
+		problem("nibe_callback", "is required (flag -nibe-callback or $NIBE_CALLBACK_URL)")
+	} else if u, err := url.Parse(s.CallbackURL); err != nil || !u.IsAbs() {
+		problem("nibe_callback", "%q is not an absolute URL", s.CallbackURL)
+	}
+
+	if s.System < 0 {
+		problem("nibe_system", "%d is not a valid system ID", s.System)
+	}
+	homes := make([]string, 0, len(s.Homes))
+	for home := range s.Homes {
+		homes = append(homes, home)
+	}
+	sort.Strings(homes)
+	for _, home := range homes {
+		if s.Homes[home] < 0 {
+			problem("homes."+home, "%d is not a valid system ID", s.Homes[home])
+		}
+	}
+	if len(s.configuredSystems()) == 0 {
+		problem("nibe_system", "at least one NIBE system is required (flag -nibe-system, $NIBE_SYSTEM_ID or homes)")
+	}
+
+	if s.PollInterval <= 0 {
+		problem("interval", "must be a positive number of seconds (flag -interval), got %d", s.PollInterval)
+	}
+	if !validTargetTemperature(s.TargetTemperature) {
+		problem("target_temperature", "%d is outside of %d (%.1f °C) to %d (%.1f °C)", s.TargetTemperature,
+			minTargetTemperature, float64(minTargetTemperature)/10, maxTargetTemperature, float64(maxTargetTemperature)/10)
+	}
+	if t := s.AwayTargetTemperature; t != 0 && !validTargetTemperature(t) {
+		problem("away_target_temperature", "%d is outside of %d to %d", t, minTargetTemperature, maxTargetTemperature)
+	}
+	if _, err := parseLogLevel(s.LogLevel); err != nil {
+		problem("log_level", "must be debug, info, warn or error (flag -log-level or $LOG_LEVEL), got %q", s.LogLevel)
+	}
+	if s.LogFormat != "" && s.LogFormat != "text" && s.LogFormat != "json" {
+		problem("log_format", "must be text or json (flag -log-format or $LOG_FORMAT), got %q", s.LogFormat)
+	}
+	if s.HTTPPort < 0 || s.HTTPPort > 65535 {
+		problem("http_port", "%d is not a valid port number", s.HTTPPort)
+	}
+
+	systems := make([]int, 0, len(s.Systems))
+	for system := range s.Systems {
+		systems = append(systems, system)
+	}
+	sort.Ints(systems)
+	for _, system := range systems {
+		field := fmt.Sprintf("nibe_systems.%d", system)
+		settings := s.Systems[system]
+		if t := settings.TargetTemperature; t != 0 && !validTargetTemperature(t) {
+			problem(field+".target_temperature", "%d is outside of %d to %d", t, minTargetTemperature, maxTargetTemperature)
+		}
+		for _, cs := range settings.ClimateSystems {
+			if cs < 1 {
+				problem(field+".climate_systems", "%d is not a valid climate system", cs)
+			}
+		}
+	}
+
+	if s.TLS.CertFile == "" && s.TLS.KeyFile != "" {
+		problem("tls.cert_file", "is required if tls.key_file is set")
+	}
+	if s.TLS.CertFile != "" && s.TLS.KeyFile == "" {
+		problem("tls.key_file", "is required if tls.cert_file is set")
+	}
+
+	for _, p := range s.HTTPAuth.validate() {
+		problem("http_auth.users", "%s", p)
+	}
+
+	if _, err := s.Alerts.Notifiers(); err != nil {
+		problem("alerts", "%v", err)
+	}
+
+	return errors.Join(errs...)
+}
+
+func validTargetTemperature(t int) bool {
+	return t >= minTargetTemperature && t <= maxTargetTemperature
+}
+
+// historyRetention returns how long readings are kept, or 0 if the history
+// is disabled.
+func (s *SystemSettings) historyRetention() time.Duration {
+	switch {
+	case s.HistoryRetention < 0:
+		return 0
+	case s.HistoryRetention == 0:
+		return 30 * 24 * time.Hour
+	default:
+		return time.Duration(s.HistoryRetention) * 24 * time.Hour
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ingmarstein/velux-nibe/alert"
+)
+
+func validSettings() SystemSettings {
+	return SystemSettings{
+		Username:          "user",
+		Password:          "secret",
+		ClientID:          "id",
+		ClientSecret:      "secret",
+		CallbackURL:       "https://example.com/callback",
+		System:            1,
+		PollInterval:      60,
+		TargetTemperature: 210,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(s *SystemSettings)
+		// fields lists the settings reported as invalid
+		fields []string
+	}{
+		{"valid", func(s *SystemSettings) {}, nil},
+		{"homes instead of system", func(s *SystemSettings) {
+			s.System = 0
+			s.Homes = map[string]int{"home": 2}
+		}, nil},
+		{"missing credentials", func(s *SystemSettings) {
+			s.Username = ""
+			s.Password = ""
+			s.ClientID = ""
+			s.ClientSecret = ""
+			s.CallbackURL = ""
+		}, []string{"velux_user", "velux_password", "nibe_client_id", "nibe_client_secret", "nibe_callback"}},
+		{"relative callback", func(s *SystemSettings) { s.CallbackURL = "/callback" }, []string{"nibe_callback"}},
+		{"no system", func(s *SystemSettings) { s.System = 0 }, []string{"nibe_system"}},
+		{"negative home system", func(s *SystemSettings) { s.Homes = map[string]int{"home": -1} }, []string{"homes.home"}},
+		{"interval", func(s *SystemSettings) { s.PollInterval = 0 }, []string{"interval"}},
+		{"target temperature", func(s *SystemSettings) { s.TargetTemperature = 21 }, []string{"target_temperature"}},
+		{"away target temperature", func(s *SystemSettings) { s.AwayTargetTemperature = 400 }, []string{"away_target_temperature"}},
+		{"system settings", func(s *SystemSettings) {
+			s.Systems = map[int]NIBESystemSettings{1: {TargetTemperature: 50, ClimateSystems: []int{0}}}
+		}, []string{"nibe_systems.1.target_temperature", "nibe_systems.1.climate_systems"}},
+		{"log settings", func(s *SystemSettings) {
+			s.LogLevel = "verbose"
+			s.LogFormat = "xml"
+		}, []string{"log_level", "log_format"}},
+		{"http port", func(s *SystemSettings) { s.HTTPPort = 70000 }, []string{"http_port"}},
+		{"tls key without cert", func(s *SystemSettings) { s.TLS.KeyFile = "key.pem" }, []string{"tls.cert_file"}},
+		{"tls cert without key", func(s *SystemSettings) { s.TLS.CertFile = "cert.pem" }, []string{"tls.key_file"}},
+		{"plaintext password", func(s *SystemSettings) {
+			s.HTTPAuth.Users = map[string]string{"admin": "admin"}
+		}, []string{"http_auth.users"}},
+		{"incomplete notifier", func(s *SystemSettings) {
+			s.Alerts.Gotify = []*alert.Gotify{{URL: "https://gotify.example.com"}}
+		}, []string{"alerts"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := validSettings()
+			tt.modify(&s)
+			err := s.Validate()
+			var fields []string
+			if err != nil {
+				for _, line := range strings.Split(err.Error(), "\n") {
+					field, _, _ := strings.Cut(line, ": ")
+					fields = append(fields, field)
+				}
+			}
+			if !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("got problems with %v, want %v (%v)", fields, tt.fields, err)
+			}
+		})
+	}
+}
+
+// useConfigFile writes a config file and points -conf to it for the duration
+// of the test.
+func useConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	old := *configFile
+	*configFile = path
+	t.Cleanup(func() { *configFile = old })
+	return path
+}
+
+func TestConfigCheckCommand(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		want   int
+	}{
+		{"valid", `{"velux_user": "user", "velux_password": "secret", "nibe_client_id": "id", "nibe_client_secret": "secret",
+			"nibe_callback": "https://example.com/callback", "nibe_system": 1, "interval": 60, "target_temperature": 210}`, 0},
+		{"invalid", `{"velux_user": "user", "interval": 60, "target_temperature": 210}`, 1},
+		{"malformed", `{"velux_user": `, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfigFile(t, "velux-nibe.json", tt.config)
+			if got := configCheckCommand(); got != tt.want {
+				t.Errorf("got exit code %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("VELUX_NIBE_TEST_SECRET", "s3cret")
+
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{"reference", "${VELUX_NIBE_TEST_SECRET}", "s3cret", false},
+		{"embedded", "user:${VELUX_NIBE_TEST_SECRET}@host", "user:s3cret@host", false},
+		{"escaped", "$${VELUX_NIBE_TEST_SECRET}", "${VELUX_NIBE_TEST_SECRET}", false},
+		{"bcrypt hash", "$2a$10$abc", "$2a$10$abc", false},
+		{"no name", "${}", "${}", false},
+		{"undefined", "${VELUX_NIBE_TEST_UNDEFINED}", "", true},
+		{"nested", map[string]interface{}{"a": []interface{}{"${VELUX_NIBE_TEST_SECRET}", 1.5}},
+			map[string]interface{}{"a": []interface{}{"s3cret", 1.5}}, false},
+		{"other types", true, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandEnv(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(s *SystemSettings)
+		check   func(s *SystemSettings) string
+		wantErr string
+	}{
+		{"velux password", func(s *SystemSettings) { s.PasswordFile = secret },
+			func(s *SystemSettings) string { return s.Password }, ""},
+		{"nibe client secret", func(s *SystemSettings) { s.ClientSecretFile = secret },
+			func(s *SystemSettings) string { return s.ClientSecret }, ""},
+		{"api token", func(s *SystemSettings) { s.HTTPAuth.APITokenFile = secret },
+			func(s *SystemSettings) string { return s.HTTPAuth.APIToken }, ""},
+		{"ntfy token", func(s *SystemSettings) {
+			s.Alerts.Ntfy = []*alert.Ntfy{{URL: "https://ntfy.sh/topic", TokenFile: secret}}
+		}, func(s *SystemSettings) string { return s.Alerts.Ntfy[0].Token }, ""},
+		{"gotify token", func(s *SystemSettings) {
+			s.Alerts.Gotify = []*alert.Gotify{{URL: "https://gotify.example.com", TokenFile: secret}}
+		}, func(s *SystemSettings) string { return s.Alerts.Gotify[0].Token }, ""},
+		{"smtp password", func(s *SystemSettings) {
+			s.Alerts.SMTP = []*alert.SMTP{{}, {PasswordFile: secret}}
+		}, func(s *SystemSettings) string { return s.Alerts.SMTP[1].Password }, ""},
+		{"both", func(s *SystemSettings) {
+			s.Alerts.Gotify = []*alert.Gotify{{Token: "token", TokenFile: secret}}
+		}, nil, "alerts.gotify[0].token and alerts.gotify[0].token_file are mutually exclusive"},
+		{"missing file", func(s *SystemSettings) {
+			s.Password = ""
+			s.PasswordFile = filepath.Join(dir, "missing")
+		}, nil, "failed to read velux_password_file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := validSettings()
+			s.Password = ""
+			s.ClientSecret = ""
+			tt.modify(&s)
+			err := s.readSecretFiles()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.check(&s); got != "s3cret" {
+				t.Errorf("got secret %q, want the trimmed file contents", got)
+			}
+		})
+	}
+}
+
+func TestDecodeConfig(t *testing.T) {
+	t.Setenv("VELUX_NIBE_TEST_SECRET", "s3cret")
+	want := SystemSettings{
+		Username:          "user",
+		Password:          "s3cret",
+		System:            1,
+		PollInterval:      60,
+		TargetTemperature: 215,
+		Homes:             map[string]int{"abc": 2},
+		Systems:           map[int]NIBESystemSettings{2: {ClimateSystems: []int{1, 2}, TargetTemperature: 200}},
+	}
+
+	tests := []struct {
+		format string
+		config string
+	}{
+		{formatJSON, `{
+			"velux_user": "user",
+			"velux_password": "${VELUX_NIBE_TEST_SECRET}",
+			"nibe_system": 1,
+			"interval": 60,
+			"target_temperature": 215,
+			"homes": {"abc": 2},
+			"nibe_systems": {"2": {"climate_systems": [1, 2], "target_temperature": 200}}
+		}`},
+		{formatYAML, `
+velux_user: user
+velux_password: ${VELUX_NIBE_TEST_SECRET}
+nibe_system: 1
+interval: 60
+target_temperature: 215
+homes:
+  abc: 2
+nibe_systems:
+  2:
+    climate_systems: [1, 2]
+    target_temperature: 200
+`},
+		{formatTOML, `
+velux_user = "user"
+velux_password = "${VELUX_NIBE_TEST_SECRET}"
+nibe_system = 1
+interval = 60
+target_temperature = 215
+
+[homes]
+abc = 2
+
+[nibe_systems.2]
+climate_systems = [1, 2]
+target_temperature = 200
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var got SystemSettings
+			if err := decodeConfig(strings.NewReader(tt.config), tt.format, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	t.Run("empty yaml", func(t *testing.T) {
+		var got SystemSettings
+		if err := decodeConfig(strings.NewReader(""), formatYAML, &got); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("undefined variable", func(t *testing.T) {
+		var got SystemSettings
+		err := decodeConfig(strings.NewReader("velux_password: ${VELUX_NIBE_TEST_UNDEFINED}"), formatYAML, &got)
+		if err == nil || !strings.Contains(err.Error(), "VELUX_NIBE_TEST_UNDEFINED") {
+			t.Errorf("got error %v, want one naming the variable", err)
+		}
+	})
+}
+
+func TestNormalizeKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{"integer keys", map[interface{}]interface{}{2: "a", int64(3): "b", uint64(4): "c", true: "d", "e": "f"},
+			map[string]interface{}{"2": "a", "3": "b", "4": "c", "true": "d", "e": "f"}, false},
+		{"nested", map[string]interface{}{"x": []interface{}{map[interface{}]interface{}{1: 2}}},
+			map[string]interface{}{"x": []interface{}{map[string]interface{}{"1": 2}}}, false},
+		{"float key", map[interface{}]interface{}{1.5: "a"}, nil, true},
+		{"scalar", "a", "a", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeKeys(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFormat(t *testing.T) {
+	tests := []struct {
+		path, explicit, want string
+	}{
+		{"velux-nibe.json", "", formatJSON},
+		{"velux-nibe.yml", "", formatYAML},
+		{"velux-nibe.YAML", "", formatYAML},
+		{"velux-nibe.toml", "", formatTOML},
+		{"velux-nibe.conf", "", formatJSON},
+		{"velux-nibe.conf", "yml", formatYAML},
+		{"velux-nibe.json", "toml", formatTOML},
+	}
+	for _, tt := range tests {
+		if got, err := configFormat(tt.path, tt.explicit); err != nil || got != tt.want {
+			t.Errorf("configFormat(%q, %q) = %q, %v, want %q", tt.path, tt.explicit, got, err, tt.want)
+		}
+	}
+	if _, err := configFormat("velux-nibe.json", "ini"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
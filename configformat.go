@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported config file formats.
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+	formatTOML = "toml"
+)
+
+// configFormat returns the format of the config file at path: the explicit
+// format, if given, or the one matching the file extension. Files with an
+// unknown extension are read as JSON.
+func configFormat(path, explicit string) (string, error) {
+	switch strings.ToLower(explicit) {
+	case "":
+	case formatJSON:
+		return formatJSON, nil
+	case formatYAML, "yml":
+		return formatYAML, nil
+	case formatTOML:
+		return formatTOML, nil
+	default:
+		return "", fmt.Errorf("unknown config format %q (json, yaml or toml)", explicit)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return formatYAML, nil
+	case ".toml":
+		return formatTOML, nil
+	default:
+		return formatJSON, nil
+	}
+}
+
+// decodeConfig decodes a config file in the given format into settings,
+// replacing ${NAME} references in string values with the value of the
+// environment variable NAME.
+//
+// The file is decoded into a generic document first, which is then converted
+// to JSON, so all formats use the same field names as the JSON config file.
+func decodeConfig(r io.Reader, format string, settings *SystemSettings) error {
+	var raw interface{}
+	switch format {
+	case formatYAML:
+		if err := yaml.NewDecoder(r).Decode(&raw); err != nil && err != io.EOF {
+			return err
+		}
+	case formatTOML:
+		var doc map[string]interface{}
+		if _, err := toml.NewDecoder(r).Decode(&doc); err != nil {
+			return err
+		}
+		raw = doc
+	default:
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+	}
+
+	raw, err := normalizeKeys(raw)
+	if err != nil {
+		return err
+	}
+	raw, err = expandEnv(raw)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, settings)
+}
+
+// normalizeKeys converts YAML mappings with non-string keys, such as the
+// system IDs in nibe_systems, to maps with string keys which can be encoded
+// as JSON.
+func normalizeKeys(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			switch key.(type) {
+			case string, int, int64, uint64, bool:
+			default:
+				return nil, fmt.Errorf("unsupported key %v of type %T", key, key)
+			}
+			normalized, err := normalizeKeys(value)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(key)] = normalized
+		}
+		return m, nil
+	case map[string]interface{}:
+		for key, value := range v {
+			normalized, err := normalizeKeys(value)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = normalized
+		}
+		return v, nil
+	case []interface{}:
+		for i, value := range v {
+			normalized, err := normalizeKeys(value)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = normalized
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
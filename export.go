@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ingmarstein/velux-nibe/history"
+)
+
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+}
+
+// exportReadings writes readings as CSV or newline-delimited JSON. CSV
+// temperatures are in deg. Celsius, JSON ones are multiplied by 10 as in the
+// history files. In CSV, heat pump telemetry has no home and room, and lists
+// its components separated by semicolons and its parameters as name=value
+// pairs.
+func exportReadings(w io.Writer, format string, readings []history.Reading) error {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time", "home", "room", "system", "temperature", "target", "humidity", "co2", "samples", "failures", "components", "parameters", "result"})
+		for _, r := range readings {
+			parameters := make([]string, len(r.Parameters))
+			for i, p := range r.Parameters {
+				parameters[i] = fmt.Sprintf("%s=%s%s", p.Name, strconv.FormatFloat(p.Value, 'f', -1, 64), p.Unit)
+			}
+			cw.Write([]string{
+				r.Time.Format(time.RFC3339),
+				r.Home,
+				r.Room,
+				strconv.Itoa(r.System),
+				strconv.FormatFloat(float64(r.Temperature)/10, 'f', 1, 64),
+				strconv.FormatFloat(float64(r.Target)/10, 'f', 1, 64),
+				strconv.Itoa(r.Humidity),
+				strconv.Itoa(r.CO2),
+				strconv.Itoa(max(r.Samples, 1)),
+				strconv.Itoa(r.Failures),
+				strings.Join(r.Components, ";"),
+				strings.Join(parameters, ";"),
+				r.Result,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for _, r := range readings {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported format %q (must be csv or ndjson)", format)
+	}
+}
+
+// ExportHandler exports the recorded readings. In addition to the query
+// parameters described in parseHistoryQuery, format selects csv (default) or
+// ndjson.
+func (state *SystemState) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if state.history == nil {
+		http.Error(w, "History is disabled", http.StatusNotFound)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unsupported format %q", format), http.StatusBadRequest)
+		return
+	}
+	q, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	readings, err := state.history.Query(q)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read history: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="velux-nibe-%s.%s"`, q.From.Format("20060102"), format))
+	if err := exportReadings(w, format, readings); err != nil {
+		httpLog.Error("Failed to export history", "error", err)
+	}
+}
+
+// exportCommand writes the recorded readings to stdout.
+func exportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "csv", "Output format (csv or ndjson)")
+	from := fs.String("from", "-24h", "Start of the time range (RFC 3339 or duration relative to now)")
+	to := fs.String("to", "", "End of the time range (RFC 3339 or duration relative to now, default: now)")
+	room := fs.String("room", "", "Only export readings of this room (ID or name)")
+	interval := fs.String("interval", "", "Average readings over intervals of this length, e.g. 1h")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if _, ok := exportContentTypes[*format]; !ok {
+		fmt.Fprintf(os.Stderr, "Unsupported format %q (must be csv or ndjson)\n", *format)
+		return 2
+	}
+
+	values := url.Values{}
+	for name, v := range map[string]string{"from": *from, "to": *to, "room": *room, "interval": *interval} {
+		if v != "" {
+			values.Set(name, v)
+		}
+	}
+	q, err := parseHistoryQuery(values)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	settings := loadSettings()
+	retention := settings.historyRetention()
+	if retention <= 0 {
+		fmt.Fprintln(os.Stderr, "History is disabled")
+		return 1
+	}
+	// history.Open would create the directory, so check for it first
+	if _, err := os.Stat(settings.HistoryDir); errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "No history recorded in %s\n", settings.HistoryDir)
+		return 1
+	}
+	store, err := history.Open(settings.HistoryDir, retention)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open history: %v\n", err)
+		return 1
+	}
+	readings, err := store.Query(q)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read history: %v\n", err)
+		return 1
+	}
+	if err := exportReadings(os.Stdout, *format, readings); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// ExternalIDs maps Velux rooms to the external thermostat IDs reported to
+// NIBE Uplink. The mapping is persisted so that IDs stay stable across
+// restarts and never collide, even when several Velux homes are involved.
+type ExternalIDs struct {
+	mu   sync.Mutex
+	path string
+	ids  map[string]int
+}
+
+func roomKey(homeID, roomID string) string {
+	return homeID + "/" + roomID
+}
+
+// LoadExternalIDs reads the mapping stored at path. A missing file results in
+// an empty mapping. An error is returned if two rooms share the same ID.
+func LoadExternalIDs(path string) (*ExternalIDs, error) {
+	e := &ExternalIDs{path: path, ids: make(map[string]int)}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return e, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&e.ids); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	if err := e.checkCollisions(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return e, nil
+}
+
+func (e *ExternalIDs) checkCollisions() error {
+	keys := make([]string, 0, len(e.ids))
+	for key := range e.ids {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	owners := make(map[int]string)
+	for _, key := range keys {
+		id := e.ids[key]
+		if id <= 0 || id > math.MaxInt32 {
+			return fmt.Errorf("room %s has invalid external ID %d", key, id)
+		}
+		if other, ok := owners[id]; ok {
+			return fmt.Errorf("rooms %s and %s share external ID %d", other, key, id)
+		}
+		owners[id] = key
+	}
+	return nil
+}
+
+// Lookup returns the external ID of the given room, allocating and
+// persisting a new one if the room hasn't been seen before.
+func (e *ExternalIDs) Lookup(homeID, roomID string) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key := roomKey(homeID, roomID)
+	if id, ok := e.ids[key]; ok {
+		return id, nil
+	}
+
+	id := e.allocate(key, roomID)
+	e.ids[key] = id
+	if err := e.save(); err != nil {
+		delete(e.ids, key)
+		return 0, err
+	}
+	return id, nil
+}
+
+// Get returns the external ID of the given room, if one has been allocated.
+func (e *ExternalIDs) Get(homeID, roomID string) (int, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	id, ok := e.ids[roomKey(homeID, roomID)]
+	return id, ok
+}
+
+// Contains reports whether id has been allocated to any room.
+func (e *ExternalIDs) Contains(id int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, v := range e.ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// allocate picks a free ID for key. Numeric room IDs are reduced modulo
+// math.MaxInt32, the largest value accepted by NIBE Uplink, just like earlier
+// versions did, so that the thermostats they created keep their identity.
+// Otherwise, the ID is derived from a hash of the key and probed linearly
+// until a free slot is found.
+func (e *ExternalIDs) allocate(key, roomID string) int {
+	used := make(map[int]bool, len(e.ids))
+	for _, id := range e.ids {
+		used[id] = true
+	}
+
+	if id, err := strconv.Atoi(roomID); err == nil && id > 0 {
+		if legacy := id % math.MaxInt32; legacy != 0 && !used[legacy] {
+			return legacy
+		}
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	id := int(h.Sum32() & math.MaxInt32)
+	for id == 0 || used[id] {
+		id = (id + 1) & math.MaxInt32
+	}
+	return id
+}
+
+func (e *ExternalIDs) save() error {
+	data, err := json.MarshalIndent(e.ids, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(e.path, data, 0600)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
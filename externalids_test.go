@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestExternalIDsLegacyMapping(t *testing.T) {
+	ids, err := LoadExternalIDs(filepath.Join(t.TempDir(), "ids.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		roomID string
+		want   int
+	}{
+		// small numeric IDs are used as-is
+		{"1234567890", 1234567890},
+		// earlier versions sent larger IDs modulo math.MaxInt32
+		{"5000000000", 5000000000 % math.MaxInt32},
+		{strconv.Itoa(math.MaxInt32 + 42), 42},
+	}
+	for _, tt := range tests {
+		id, err := ids.Lookup("home", tt.roomID)
+		if err != nil {
+			t.Fatalf("Lookup(%s): %v", tt.roomID, err)
+		}
+		if id != tt.want {
+			t.Errorf("Lookup(%s) = %d, want %d", tt.roomID, id, tt.want)
+		}
+	}
+}
+
+func TestExternalIDsCollisions(t *testing.T) {
+	ids, err := LoadExternalIDs(filepath.Join(t.TempDir(), "ids.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same room ID in two homes, and a room ID which is congruent to it
+	a, err := ids.Lookup("home1", "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ids.Lookup("home2", "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ids.Lookup("home1", strconv.Itoa(math.MaxInt32+42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// non-numeric and zero room IDs get a hashed ID
+	d, err := ids.Lookup("home1", "living-room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := ids.Lookup("home1", strconv.Itoa(math.MaxInt32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a != 42 {
+		t.Errorf("got %d for the first room, want 42", a)
+	}
+	seen := make(map[int]bool)
+	for _, id := range []int{a, b, c, d, e} {
+		if id <= 0 || id > math.MaxInt32 {
+			t.Errorf("ID %d is out of range", id)
+		}
+		if seen[id] {
+			t.Errorf("ID %d was allocated twice", id)
+		}
+		seen[id] = true
+	}
+
+	if again, _ := ids.Lookup("home2", "42"); again != b {
+		t.Errorf("got %d for a known room, want %d", again, b)
+	}
+}
+
+func TestExternalIDsPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ids.json")
+	ids, err := LoadExternalIDs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := ids.Lookup("home", "living-room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := ids.Lookup("home", "42")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := LoadExternalIDs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.Contains(first) || !reloaded.Contains(second) {
+		t.Errorf("reloaded mapping lacks %d or %d", first, second)
+	}
+	if id, _ := reloaded.Lookup("home", "living-room"); id != first {
+		t.Errorf("got %d after reloading, want %d", id, first)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"home/a": 7, "home/b": 7}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadExternalIDs(path); err == nil {
+		t.Error("loading a mapping with a shared ID succeeded")
+	}
+}
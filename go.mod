@@ -2,4 +2,9 @@ module github.com/ingmarstein/velux-nibe
 
 go 1.22.3
 
-require golang.org/x/oauth2 v0.21.0
+require (
+	github.com/BurntSushi/toml v1.4.0
+	golang.org/x/crypto v0.24.0
+	golang.org/x/oauth2 v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// readySyncIntervals defines how many poll intervals may pass without a
+// successful sync before velux-nibe is no longer considered ready.
+const readySyncIntervals = 3
+
+// componentHealth tracks the outcome of the most recent calls to a remote
+// service.
+type componentHealth struct {
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastError   error
+}
+
+// record stores the outcome of a call.
+func (h *componentHealth) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastError = err
+	if err == nil {
+		h.lastSuccess = time.Now()
+	}
+}
+
+// ComponentStatus is the readiness of a single component as reported by
+// /readyz. It doesn't include the errors of the remote services, as /readyz
+// doesn't require authentication; these are logged instead.
+type ComponentStatus struct {
+	Ready bool `json:"ready"`
+}
+
+// status reports a component as ready unless its most recent call failed.
+// The clients authenticate when they are created, so a component without
+// any calls yet is ready.
+func (h *componentHealth) status() ComponentStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return ComponentStatus{Ready: h.lastError == nil}
+}
+
+// syncStatus reports the sync as ready if it succeeded within the last
+// readySyncIntervals poll intervals.
+func (state *SystemState) syncStatus() ComponentStatus {
+	maxAge := readySyncIntervals * state.pollInterval()
+
+	h := &state.syncHealth
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return ComponentStatus{Ready: !h.lastSuccess.IsZero() && time.Since(h.lastSuccess) <= maxAge}
+}
+
+// HealthzHandler reports that the process is alive.
+func (state *SystemState) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyzHandler reports whether both clients work and rooms are synced. It
+// responds with 503 Service Unavailable if any component is not ready.
+func (state *SystemState) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	components := map[string]ComponentStatus{
+		"velux": state.veluxHealth.status(),
+		"nibe":  state.nibeHealth.status(),
+		"sync":  state.syncStatus(),
+	}
+	ready := true
+	for _, c := range components {
+		ready = ready && c.Ready
+	}
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, struct {
+		Ready      bool                       `json:"ready"`
+		Components map[string]ComponentStatus `json:"components"`
+	}{ready, components})
+}
+
+// healthcheckCommand queries /readyz, or /healthz with -live, of the running
+// instance and exits with a non-zero code if it is not ready. It is meant to
+// be used as Docker HEALTHCHECK.
+func healthcheckCommand(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	live := fs.Bool("live", false, "only check that the process is alive")
+	target := fs.String("url", "", "URL of the endpoint to query (default: derived from the settings)")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout of the request")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	endpoint := "/readyz"
+	if *live {
+		endpoint = "/healthz"
+	}
+	if *target == "" {
+		settings, err := readSettings()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		// the HTTP interface is off by default, which must not mark the
+		// container as unhealthy
+		if settings.HTTPPort == 0 {
+			fmt.Println("HTTP interface is disabled (set HTTP_PORT to check it), skipping the health check")
+			return 0
+		}
+		scheme := "http"
+		if settings.TLS.enabled() {
+			scheme = "https"
+		}
+		*target = fmt.Sprintf("%s://localhost:%d%s", scheme, settings.HTTPPort, endpoint)
+	}
+
+	client := &http.Client{
+		Timeout: *timeout,
+		Transport: &http.Transport{
+			// the certificate is usually not issued for localhost
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	resp, err := client.Get(*target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Health check failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	io.Copy(os.Stdout, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "Health check failed: %s\n", resp.Status)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadyzOmitsErrors(t *testing.T) {
+	state := &SystemState{Settings: SystemSettings{PollInterval: 60}}
+	state.nibeHealth.record(errors.New("GET https://api.nibeuplink.com/api/v1/systems/12345: 401 Unauthorized"))
+
+	w := httptest.NewRecorder()
+	state.ReadyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	want := `{
+  "ready": false,
+  "components": {
+    "nibe": {
+      "ready": false
+    },
+    "sync": {
+      "ready": false
+    },
+    "velux": {
+      "ready": true
+    }
+  }
+}`
+	if body := strings.TrimSpace(w.Body.String()); body != want {
+		t.Errorf("got %s, want %s", body, want)
+	}
+}
+
+func TestHealthcheckCommand(t *testing.T) {
+	// without an HTTP port, there is nothing to check
+	useConfigFile(t, "velux-nibe.json", `{"velux_user": "user"}`)
+	if got := healthcheckCommand(nil); got != 0 {
+		t.Errorf("got exit code %d with the HTTP interface disabled, want 0", got)
+	}
+
+	state := &SystemState{Settings: SystemSettings{PollInterval: 60}}
+	server := httptest.NewServer(state.routes())
+	defer server.Close()
+	if got := healthcheckCommand([]string{"-url", server.URL + "/healthz"}); got != 0 {
+		t.Errorf("got exit code %d for /healthz, want 0", got)
+	}
+	// no sync has succeeded yet
+	if got := healthcheckCommand([]string{"-url", server.URL + "/readyz"}); got != 1 {
+		t.Errorf("got exit code %d for /readyz, want 1", got)
+	}
+}
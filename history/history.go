@@ -0,0 +1,331 @@
+// Package history stores room readings and heat pump telemetry in append-only
+// JSON lines files, one file per day, and deletes files older than the
+// retention period.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileSuffix = ".jsonl"
+	dateLayout = "2006-01-02"
+)
+
+// Reading is a single room reading and the result of reporting it to NIBE
+// Uplink, or the telemetry of a NIBE system. Telemetry readings have no home
+// and room. Temperatures are in deg. Celsius, multiplied by 10.
+type Reading struct {
+	Time        time.Time `json:"time"`
+	HomeID      string    `json:"home_id"`
+	Home        string    `json:"home"`
+	RoomID      string    `json:"room_id"`
+	Room        string    `json:"room"`
+	System      int       `json:"system,omitempty"`
+	Temperature int       `json:"temperature"`
+	Target      int       `json:"target"`
+	Humidity    int       `json:"humidity,omitempty"`
+	CO2         int       `json:"co2,omitempty"`
+	// Components lists the active components of the heat pump
+	Components []string `json:"components,omitempty"`
+	// Parameters holds the heat pump parameters of telemetry readings
+	Parameters []Parameter `json:"parameters,omitempty"`
+	// Result is the error returned by NIBE Uplink, if any
+	Result string `json:"result,omitempty"`
+	// Samples is the number of readings aggregated into this one, if
+	// downsampled
+	Samples int `json:"samples,omitempty"`
+	// Failures is the number of aggregated readings which failed, if
+	// downsampled
+	Failures int `json:"failures,omitempty"`
+}
+
+// Parameter is the value of a heat pump parameter, e.g. the outdoor
+// temperature.
+type Parameter struct {
+	Name  string  `json:"name"`
+	Unit  string  `json:"unit,omitempty"`
+	Value float64 `json:"value"`
+}
+
+// IsTelemetry reports whether r holds heat pump telemetry rather than a room
+// reading.
+func (r Reading) IsTelemetry() bool {
+	return r.HomeID == "" && r.RoomID == ""
+}
+
+// key identifies the room or system the reading belongs to.
+func (r Reading) key() string {
+	if r.IsTelemetry() {
+		return fmt.Sprintf("system/%d", r.System)
+	}
+	return r.HomeID + "/" + r.RoomID
+}
+
+// Query selects readings from the store.
+type Query struct {
+	// Time range, inclusive From and exclusive To. A zero To means now.
+	From, To time.Time
+	// Only return readings of rooms with this ID or name, if set. Telemetry
+	// is omitted in this case.
+	Room string
+	// Aggregate readings of each room into intervals of this length, if set
+	Interval time.Duration
+}
+
+// Store is an append-only history of room readings and telemetry.
+type Store struct {
+	dir       string
+	retention time.Duration
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+// Open returns a store keeping its files in dir, creating it if necessary.
+// Files older than retention are deleted.
+func Open(dir string, retention time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, retention: retention}, nil
+}
+
+func (s *Store) fileName(day time.Time) string {
+	return filepath.Join(s.dir, day.UTC().Format(dateLayout)+fileSuffix)
+}
+
+// Append adds readings to the store.
+func (s *Store) Append(readings ...Reading) error {
+	if len(readings) == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var f *os.File
+	var current string
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	for _, r := range readings {
+		name := s.fileName(r.Time)
+		if name != current {
+			if f != nil {
+				if err := f.Close(); err != nil {
+					return err
+				}
+			}
+			var err error
+			f, err = os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+			if err != nil {
+				f = nil
+				return err
+			}
+			current = name
+		}
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(append(data, '\n')); err != nil {
+			return err
+		}
+	}
+
+	if time.Since(s.lastPruned) > time.Hour {
+		s.lastPruned = time.Now()
+		return s.prune()
+	}
+	return nil
+}
+
+// prune deletes the files which are entirely outside the retention period.
+func (s *Store) prune() error {
+	if s.retention <= 0 {
+		return nil
+	}
+	days, err := s.days()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-s.retention).UTC().Truncate(24 * time.Hour)
+	for _, day := range days {
+		if day.Add(24 * time.Hour).Before(cutoff) {
+			if err := os.Remove(s.fileName(day)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// days returns the days for which files exist, oldest first.
+func (s *Store) days() ([]time.Time, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var days []time.Time
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), fileSuffix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		day, err := time.Parse(dateLayout, name)
+		if err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days, nil
+}
+
+// Query returns the readings matching q, oldest first.
+func (s *Store) Query(q Query) ([]Reading, error) {
+	to := q.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	// only list the files under the lock, so reading them doesn't block
+	// Append. A line which is being appended is skipped by scan.
+	s.mu.Lock()
+	days, err := s.days()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var readings []Reading
+	for _, day := range days {
+		if !day.Add(24*time.Hour).After(q.From) || !day.Before(to) {
+			continue
+		}
+		err := s.scan(s.fileName(day), func(r Reading) {
+			if r.Time.Before(q.From) || !r.Time.Before(to) {
+				return
+			}
+			if q.Room != "" && r.RoomID != q.Room && r.Room != q.Room {
+				return
+			}
+			readings = append(readings, r)
+		})
+		// the file may have been pruned in the meantime
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(readings, func(i, j int) bool { return readings[i].Time.Before(readings[j].Time) })
+	if q.Interval > 0 {
+		readings = Downsample(readings, q.Interval)
+	}
+	return readings, nil
+}
+
+func (s *Store) scan(name string, fn func(Reading)) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Reading
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// a crash may leave a truncated last line behind
+			continue
+		}
+		fn(r)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// Downsample aggregates the readings of each room or system into intervals of
+// the given length. Numeric values are averaged, the result of the last failed
+// reading and the components of the last reading are kept. The readings must
+// be sorted by time.
+func Downsample(readings []Reading, interval time.Duration) []Reading {
+	type bucket struct {
+		reading                                 Reading
+		temperature, target, humidity, co2, num int
+		// parameter sums and counts, in order of appearance
+		parameters []Parameter
+		counts     []int
+	}
+	var order []string
+	buckets := make(map[string]*bucket)
+
+	for _, r := range readings {
+		start := r.Time.Truncate(interval)
+		key := r.key() + "@" + start.String()
+		b, ok := buckets[key]
+		if !ok {
+			b = &bucket{reading: r}
+			b.reading.Time = start
+			b.reading.Result = ""
+			buckets[key] = b
+			order = append(order, key)
+		}
+		b.temperature += r.Temperature
+		b.target += r.Target
+		b.humidity += r.Humidity
+		b.co2 += r.CO2
+		b.num++
+		b.reading.Components = r.Components
+	params:
+		for _, p := range r.Parameters {
+			for i := range b.parameters {
+				if b.parameters[i].Name == p.Name {
+					b.parameters[i].Value += p.Value
+					b.counts[i]++
+					continue params
+				}
+			}
+			b.parameters = append(b.parameters, p)
+			b.counts = append(b.counts, 1)
+		}
+		if r.Result != "" {
+			b.reading.Result = r.Result
+			b.reading.Failures++
+		}
+	}
+
+	result := make([]Reading, 0, len(order))
+	for _, key := range order {
+		b := buckets[key]
+		r := b.reading
+		r.Temperature = b.temperature / b.num
+		r.Target = b.target / b.num
+		r.Humidity = b.humidity / b.num
+		r.CO2 = b.co2 / b.num
+		r.Parameters = nil
+		for i, p := range b.parameters {
+			p.Value /= float64(b.counts[i])
+			r.Parameters = append(r.Parameters, p)
+		}
+		r.Samples = b.num
+		result = append(result, r)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Time.Before(result[j].Time) })
+	return result
+}
@@ -0,0 +1,161 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestQuery(t *testing.T) {
+	s, err := Open(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	readings := []Reading{
+		{Time: day.Add(-time.Hour), HomeID: "h", RoomID: "1", Room: "Kitchen", Temperature: 200},
+		{Time: day.Add(time.Hour), HomeID: "h", RoomID: "1", Room: "Kitchen", Temperature: 210},
+		{Time: day.Add(time.Hour), System: 7, Parameters: []Parameter{{Name: "outdoor", Value: 5}}},
+		{Time: day.Add(2 * time.Hour), HomeID: "h", RoomID: "2", Room: "Bedroom", Temperature: 180},
+		{Time: day.Add(25 * time.Hour), HomeID: "h", RoomID: "1", Room: "Kitchen", Temperature: 220},
+	}
+	if err := s.Append(readings...); err != nil {
+		t.Fatal(err)
+	}
+	// a truncated last line is skipped
+	f, err := os.OpenFile(s.fileName(day), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2024-03-01T05:00:00Z","home_`)
+	f.Close()
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []Reading
+	}{
+		{"everything", Query{To: day.Add(48 * time.Hour)}, readings},
+		{"inclusive from", Query{From: day.Add(time.Hour), To: day.Add(48 * time.Hour)}, readings[1:]},
+		{"exclusive to", Query{From: day, To: day.Add(2 * time.Hour)}, readings[1:3]},
+		{"single day", Query{From: day, To: day.Add(24 * time.Hour)}, readings[1:4]},
+		{"room ID", Query{From: day, To: day.Add(48 * time.Hour), Room: "1"}, []Reading{readings[1], readings[4]}},
+		{"room name", Query{From: day, To: day.Add(48 * time.Hour), Room: "Bedroom"}, readings[3:4]},
+		{"empty", Query{From: day.Add(-48 * time.Hour), To: day.Add(-24 * time.Hour)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Query(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range got {
+				got[i].Time = got[i].Time.UTC()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownsample(t *testing.T) {
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	tests := []struct {
+		name     string
+		readings []Reading
+		want     []Reading
+	}{
+		{
+			name: "room averages",
+			readings: []Reading{
+				{Time: at(0), HomeID: "h", RoomID: "1", Temperature: 200, Target: 210, Humidity: 40, CO2: 500},
+				{Time: at(10), HomeID: "h", RoomID: "1", Temperature: 210, Target: 210, Humidity: 50, CO2: 700, Result: "timeout"},
+				{Time: at(20), HomeID: "h", RoomID: "1", Temperature: 220, Target: 210, Humidity: 60, CO2: 600},
+				{Time: at(70), HomeID: "h", RoomID: "1", Temperature: 230, Target: 220},
+			},
+			want: []Reading{
+				{Time: at(0), HomeID: "h", RoomID: "1", Temperature: 210, Target: 210, Humidity: 50, CO2: 600, Result: "timeout", Samples: 3, Failures: 1},
+				{Time: at(60), HomeID: "h", RoomID: "1", Temperature: 230, Target: 220, Samples: 1},
+			},
+		},
+		{
+			name: "rooms are kept apart",
+			readings: []Reading{
+				{Time: at(0), HomeID: "h", RoomID: "1", Temperature: 200},
+				{Time: at(5), HomeID: "h", RoomID: "2", Temperature: 180},
+				{Time: at(10), HomeID: "h", RoomID: "1", Temperature: 220},
+			},
+			want: []Reading{
+				{Time: at(0), HomeID: "h", RoomID: "1", Temperature: 210, Samples: 2},
+				{Time: at(0), HomeID: "h", RoomID: "2", Temperature: 180, Samples: 1},
+			},
+		},
+		{
+			name: "telemetry",
+			readings: []Reading{
+				{Time: at(0), System: 7, Components: []string{"compressor"}, Parameters: []Parameter{{Name: "outdoor", Unit: "°C", Value: 4}}},
+				{Time: at(10), System: 7, Parameters: []Parameter{{Name: "outdoor", Unit: "°C", Value: 6}, {Name: "hot water", Value: 50}}},
+				{Time: at(20), System: 8, Parameters: []Parameter{{Name: "outdoor", Value: -1}}},
+			},
+			want: []Reading{
+				{Time: at(0), System: 7, Parameters: []Parameter{{Name: "outdoor", Unit: "°C", Value: 5}, {Name: "hot water", Value: 50}}, Samples: 2},
+				{Time: at(0), System: 8, Parameters: []Parameter{{Name: "outdoor", Value: -1}}, Samples: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Downsample(tt.readings, time.Hour); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := time.Now().UTC()
+	for _, age := range []int{0, 1, 2, 3, 4, 10} {
+		if err := os.WriteFile(s.fileName(today.AddDate(0, 0, -age)), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// other files are left alone
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.prune(); err != nil {
+		t.Fatal(err)
+	}
+
+	days, err := s.days()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []string
+	for _, day := range days {
+		kept = append(kept, day.Format(dateLayout))
+	}
+	// a day is only deleted once it ends before the start of the day the
+	// retention period begins on
+	var want []string
+	for _, age := range []int{3, 2, 1, 0} {
+		want = append(want, today.AddDate(0, 0, -age).Format(dateLayout))
+	}
+	if !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept %v, want %v", kept, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Error(err)
+	}
+}
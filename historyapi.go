@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ingmarstein/velux-nibe/history"
+)
+
+// recordHistory appends the results of a sync cycle to the history.
+func (state *SystemState) recordHistory(updates []UpdateResult) {
+	if state.history == nil {
+		return
+	}
+	readings := make([]history.Reading, 0, len(updates))
+	for _, u := range updates {
+		readings = append(readings, u.reading())
+	}
+	if err := state.history.Append(readings...); err != nil {
+		syncLog.Error("Failed to write history", "error", err)
+	}
+}
+
+// parseHistoryQuery parses the from, to, room and interval query parameters.
+// Times are given in RFC 3339 format or as a duration relative to now, e.g.
+// "-24h". By default, the last 24 hours are returned.
+func parseHistoryQuery(values url.Values) (history.Query, error) {
+	now := time.Now()
+	q := history.Query{From: now.Add(-24 * time.Hour), To: now, Room: values.Get("room")}
+
+	parseTime := func(name string, dst *time.Time) error {
+		v := values.Get(name)
+		if v == "" {
+			return nil
+		}
+		if d, err := time.ParseDuration(v); err == nil {
+			*dst = now.Add(d)
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %q", name, v)
+		}
+		*dst = t
+		return nil
+	}
+	if err := parseTime("from", &q.From); err != nil {
+		return q, err
+	}
+	if err := parseTime("to", &q.To); err != nil {
+		return q, err
+	}
+	if !q.From.Before(q.To) {
+		return q, fmt.Errorf("from must be before to")
+	}
+
+	if v := values.Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return q, fmt.Errorf("invalid interval: %q", v)
+		}
+		q.Interval = d
+	}
+	return q, nil
+}
+
+// HistoryAPIHandler returns the recorded readings matching the query
+// parameters described in parseHistoryQuery.
+func (state *SystemState) HistoryAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if state.history == nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("history is disabled"))
+		return
+	}
+	q, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	readings, err := state.history.Query(q)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if readings == nil {
+		readings = []history.Reading{}
+	}
+	writeJSON(w, http.StatusOK, readings)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// logLevel is shared by all loggers so reloaded settings can change it.
+var logLevel = new(slog.LevelVar)
+
+// Per-component loggers. They log to slog.Default() until setupLogging was
+// called.
+var (
+	veluxLog = slog.Default()
+	nibeLog  = slog.Default()
+	syncLog  = slog.Default()
+	httpLog  = slog.Default()
+)
+
+// setupLogging configures the default logger and the component loggers
+// according to the settings.
+func setupLogging(settings *SystemSettings) {
+	level, _ := settings.logLevel()
+	logLevel.Set(level)
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	if settings.LogFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+
+	veluxLog = logger.With("component", "velux")
+	nibeLog = logger.With("component", "nibe")
+	syncLog = logger.With("component", "sync")
+	httpLog = logger.With("component", "http")
+}
+
+// logLevel returns the configured log level. Verbose mode implies debug
+// unless a level was set explicitly.
+func (s *SystemSettings) logLevel() (slog.Level, error) {
+	if s.LogLevel == "" && s.Verbose {
+		return slog.LevelDebug, nil
+	}
+	return parseLogLevel(s.LogLevel)
+}
+
+// parseLogLevel parses debug, info, warn or error. The empty string means
+// info.
+func parseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs every request to the HTTP interface at debug level.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		httpLog.Debug("request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr,
+			"status", rec.status, "duration", time.Since(start))
+	})
+}
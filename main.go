@@ -1,43 +1,29 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
-	"html/template"
-	"log"
-	"math"
+	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 	_ "time/tzdata"
 
+	"github.com/ingmarstein/velux-nibe/alert"
+	"github.com/ingmarstein/velux-nibe/history"
 	"github.com/ingmarstein/velux-nibe/nibe"
+	"github.com/ingmarstein/velux-nibe/sdnotify"
 	"github.com/ingmarstein/velux-nibe/velux"
 )
 
-func lenientParseInt(s string) int {
-	v, err := strconv.Atoi(s)
-	if err != nil {
-		return 0
-	}
-	return v
-}
-
-var username = flag.String("velux-user", os.Getenv("VELUX_USERNAME"), "Velux user name")
-var password = flag.String("velux-password", os.Getenv("VELUX_PASSWORD"), "Velux password")
-var clientID = flag.String("nibe-client-id", os.Getenv("NIBE_CLIENT_ID"), "NIBE Uplink client ID")
-var clientSecret = flag.String("nibe-client-secret", os.Getenv("NIBE_CLIENT_SECRET"), "NIBE Uplink client secret")
-var callbackURL = flag.String("nibe-callback", os.Getenv("NIBE_CALLBACK_URL"), "NIBE Uplink callback URL")
-var system = flag.Int("nibe-system", lenientParseInt(os.Getenv("NIBE_SYSTEM_ID")), "NIBE system ID")
-var nibeTokenFile = flag.String("nibe-token", os.Getenv("NIBE_TOKEN"), "File name to store the NIBE token")
-var verbose = flag.Bool("verbose", false, "Verbose mode")
-var targetTemp = flag.Int("targetTemp", 210, "Target temperature in celsius, multiplied by ten")
-var pollInterval = flag.Int("interval", 60, "Polling interval in seconds")
-var httpPort = flag.Int("http-port", lenientParseInt(os.Getenv("HTTP_PORT")), "Port for HTTP interface (0 = disabled)")
-var configFile = flag.String("conf", "", "Config file")
+// shutdownTimeout limits how long in-flight HTTP requests may take after a
+// signal to stop was received. It is shorter than the 10s Docker and systemd
+// wait before killing the process.
+const shutdownTimeout = 5 * time.Second
 
 // https://medium.com/@mhcbinder/using-local-time-in-a-golang-docker-container-built-from-scratch-2900af02fbaf
 func updateTimezone() {
@@ -45,276 +31,269 @@ func updateTimezone() {
 		var err error
 		time.Local, err = time.LoadLocation(tz)
 		if err != nil {
-			log.Printf("error loading location '%s': %v\n", tz, err)
+			slog.Error("Failed to load time zone", "tz", tz, "error", err)
 		}
 	}
 }
 
 type UpdateResult struct {
 	Timestamp         time.Time
+	HomeID            string
+	Home              string
+	RoomID            string
 	Name              string
+	System            int
 	ActualTemperature int
 	TargetTemperature int
+	Humidity          int
+	CO2               int
 	Result            error
 }
 
-type SystemSettings struct {
-	Username          string `json:"velux_user"`
-	Password          string `json:"velux_password"`
-	ClientID          string `json:"nibe_client_id"`
-	ClientSecret      string `json:"nibe_client_secret"`
-	CallbackURL       string `json:"nibe_callback"`
-	System            int    `json:"nibe_system"`
-	TokenFile         string `json:"nibe_token"`
-	PollInterval      int    `json:"interval"`
-	Verbose           bool   `json:"verbose"`
-	TargetTemperature int    `json:"target_temperature"`
-	HTTPPort          int    `json:"http_port,omitempty"`
+// reading converts the result into a history record.
+func (u UpdateResult) reading() history.Reading {
+	r := history.Reading{
+		Time:        u.Timestamp,
+		HomeID:      u.HomeID,
+		Home:        u.Home,
+		RoomID:      u.RoomID,
+		Room:        u.Name,
+		System:      u.System,
+		Temperature: u.ActualTemperature,
+		Target:      u.TargetTemperature,
+		Humidity:    u.Humidity,
+		CO2:         u.CO2,
+	}
+	if u.Result != nil {
+		r.Result = u.Result.Error()
+	}
+	return r
 }
 
 type SystemState struct {
 	SettingsMu sync.RWMutex
 	Settings   SystemSettings
-
-	UpdatesMu  sync.RWMutex
-	LastUpdate []UpdateResult
+	// Runtime holds the settings changed in the HTML interface
+	Runtime RuntimeState
+	// ConfigError describes why the config file could not be reloaded
+	ConfigError string
+	// configuredTarget is the target_temperature of the config file, before
+	// the runtime state is merged over it
+	configuredTarget int
+
+	UpdatesMu        sync.RWMutex
+	LastUpdate       []UpdateResult
+	StaleThermostats []ThermostatInfo
+	Telemetry        map[int]SystemTelemetry
+	Alarms           map[int][]nibe.Notification
+	currentRooms     map[int]map[int]bool
+	lastReconcile    time.Time
+
+	SmartHomeMu sync.RWMutex
+	SmartHome   map[int]SmartHomeStatus
+
+	nibeClient  *nibe.Client
+	veluxClient *velux.Client
+	externalIDs *ExternalIDs
+	alerts      *alert.Manager
+	history     *history.Store
+	syncHealth  syncHealth
+	veluxHealth componentHealth
+	nibeHealth  componentHealth
+
+	csrfOnce  sync.Once
+	csrfToken string
+
+	// intervalChanged is signalled when a reload changes the poll interval
+	intervalChanged chan struct{}
 }
 
-var htmlTemplate = template.Must(template.New("main").Parse(`
-<!DOCTYPE html>
-<html>
-	<head>
-		<title>Velux-Nibe</title>
-	</head>
-	<body>
-		<h1>Velux-Nibe</h1>
-		<h2>Configuration</h2>
-		<table>
-			<tr><td>Velux user</td><td>{{.Settings.Username}}</td></tr>
-			<tr><td>NIBE client ID</td><td>{{.Settings.ClientID}}</td></tr>
-			<tr><td>NIBE system</td><td>{{.Settings.System}}</td></tr>
-			<tr><td>Poll interval</td><td>{{.Settings.PollInterval}}</td></tr>
-		</table>
-		<h2>Settings</h2>
-		<form method="POST" action="/">
-			<label for="target_temperature">Target temperature:</label>
-			<input type="text" name="target_temperature" value="{{.Settings.TargetTemperature}}">
-			<input type="submit" value="submit" />
-		</form>
-		<h2>Last Update</h2>
-		{{range .LastUpdate}}
-		<h3>Room {{.Name}}</h3>
-		<table>
-			<tr><td>Timestamp</td><td>{{.Timestamp.Format "Jan 02, 2006 15:04:05 UTC"}}</td></tr>
-			<tr><td>Actual temperature</td><td>{{.ActualTemperature}}</td></tr>
-			<tr><td>Target temperature</td><td>{{.TargetTemperature}}</td></tr>
-			<tr><td>Result</td><td>{{if .Result}}{{.Result}}{{else}}success{{end}}</td></tr>
-		</table>
-		{{end}}
-  </body>
-</html>
-`))
-
-func (state *SystemState) Handler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "POST" {
-		if err := r.ParseForm(); err != nil {
-			fmt.Fprintf(w, "ParseForm() err: %v", err)
-			return
-		}
-		newTempString := r.FormValue("target_temperature")
-		newTemp, err := strconv.Atoi(newTempString)
-		if err != nil {
-			fmt.Fprintf(w, "Invalid temperature: %v", err)
-			return
-		}
-
-		if newTemp < 100 || newTemp > 300 {
-			fmt.Fprintf(w, "Invalid temperature: %d (must be between 100 (10.0 °C) and 300 (30.0 °C)", newTemp)
-			return
-		}
+// SmartHomeModes returns the modes which can be selected in the HTML interface.
+func (state *SystemState) SmartHomeModes() []nibe.SmartHomeMode {
+	return nibe.SmartHomeModes
+}
 
-		state.SettingsMu.Lock()
-		defer state.SettingsMu.Unlock()
-		state.Settings.TargetTemperature = newTemp
+// logSystems lists the NIBE systems visible to the authenticated user and
+// warns about configured systems which are not among them.
+func logSystems(ctx context.Context, client *nibe.Client, configured []int) {
+	systems, err := client.GetAllSystems(ctx)
+	if err != nil {
+		nibeLog.Error("Failed to list systems", "error", err)
+		return
+	}
 
-		if *configFile != "" {
-			f, err := os.OpenFile(*configFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-			if err != nil {
-				log.Printf("Unable to write config file: %v", err)
-			} else {
-				enc := json.NewEncoder(f)
-				enc.SetIndent("", "  ")
-				enc.Encode(state.Settings)
-				f.Close()
-			}
+	visible := make(map[int]bool)
+	for _, system := range systems {
+		visible[system.SystemID] = true
+		nibeLog.Info("System", "system", system.SystemID, "name", system.Name, "product", system.ProductName, "connection", system.ConnectionStatus)
+	}
+	for _, system := range configured {
+		if !visible[system] {
+			nibeLog.Warn("Configured system is not accessible with this account", "system", system)
 		}
 	}
+}
 
-	state.UpdatesMu.RLock()
-	defer state.UpdatesMu.RUnlock()
-
-	if err := htmlTemplate.Execute(w, state); err != nil {
-		log.Fatal(err)
+// notify sends the given states to systemd, if velux-nibe runs as a notify
+// service.
+func notify(states ...string) {
+	if _, err := sdnotify.Notify(states...); err != nil {
+		slog.Warn("Failed to notify systemd", "error", err)
 	}
 }
 
 func main() {
 	updateTimezone()
 
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command]\n\n", os.Args[0])
+		fmt.Fprint(flag.CommandLine.Output(), commandUsage)
+		fmt.Fprintln(flag.CommandLine.Output(), "\nFlags:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	var flagsPassed = make(map[string]bool)
-	flag.Visit(func(f *flag.Flag) {
-		flagsPassed[f.Name] = true
-	})
+	// cancel in-flight API calls and stop all loops on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	state := SystemState{Settings: SystemSettings{}}
-
-	if *configFile != "" {
-		f, err := os.Open(*configFile)
-		if err != nil {
-			log.Fatalf("Failed to open config file: %v", err)
+	if flag.NArg() > 0 {
+		// commands which need the settings report errors reading them
+		// themselves, e.g. config check
+		if settings, err := readSettings(); err == nil {
+			setupLogging(&settings)
 		}
-		err = json.NewDecoder(f).Decode(&state.Settings)
-		if err != nil {
-			log.Fatalf("Failed to parse config file: %v", err)
-		}
-		f.Close()
+		code := runCommand(ctx, flag.Args())
+		stop()
+		os.Exit(code)
 	}
 
-	// command line flags override settings from the config file
-	if *username != "" {
-		state.Settings.Username = *username
-	}
-	if *password != "" {
-		state.Settings.Password = *password
-	}
-	if *clientID != "" {
-		state.Settings.ClientID = *clientID
-	}
-	if *clientSecret != "" {
-		state.Settings.ClientSecret = *clientSecret
-	}
-	if *callbackURL != "" {
-		state.Settings.CallbackURL = *callbackURL
-	}
-	if *nibeTokenFile != "" {
-		state.Settings.TokenFile = *nibeTokenFile
-	}
-	if *system != 0 {
-		state.Settings.System = *system
+	state := SystemState{
+		Settings:        loadSettings(),
+		SmartHome:       make(map[int]SmartHomeStatus),
+		Telemetry:       make(map[int]SystemTelemetry),
+		Alarms:          make(map[int][]nibe.Notification),
+		intervalChanged: make(chan struct{}, 1),
 	}
-	if flagsPassed["interval"] {
-		state.Settings.PollInterval = *pollInterval
-	}
-	if *verbose {
-		state.Settings.Verbose = true
+
+	if err := state.Settings.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid settings:\n%v\n\nRun %s -h for the available flags.\n", indent(err.Error()), os.Args[0])
+		os.Exit(1)
 	}
-	if flagsPassed["targetTemp"] {
-		state.Settings.TargetTemperature = *targetTemp
+	setupLogging(&state.Settings)
+
+	var err error
+	state.Runtime, err = loadRuntimeState(state.Settings.StateFile)
+	if err != nil {
+		fatal("Failed to load state file", "error", err)
 	}
-	if *httpPort != 0 {
-		state.Settings.HTTPPort = *httpPort
+	state.setSettings(state.Settings)
+
+	state.externalIDs, err = LoadExternalIDs(state.Settings.IDMapFile)
+	if err != nil {
+		fatal("Failed to load NIBE thermostat IDs", "error", err)
 	}
 
-	if state.Settings.Username == "" ||
-		state.Settings.Password == "" ||
-		state.Settings.ClientID == "" ||
-		state.Settings.ClientSecret == "" ||
-		state.Settings.CallbackURL == "" ||
-		state.Settings.System == 0 {
-		flag.Usage()
-		os.Exit(1)
+	state.alerts, err = newAlertManager(&state.Settings.Alerts)
+	if err != nil {
+		fatal("Invalid alert settings", "error", err)
 	}
 
-	if state.Settings.TokenFile == "" {
-		state.Settings.TokenFile = "nibe-token.json"
+	if retention := state.Settings.historyRetention(); retention > 0 {
+		state.history, err = history.Open(state.Settings.HistoryDir, retention)
+		if err != nil {
+			fatal("Failed to open history", "error", err)
+		}
 	}
 
-	log.Println("Creating NIBE client")
-	nibeClient := nibe.NewClientWithAuth(state.Settings.ClientID, state.Settings.ClientSecret, state.Settings.CallbackURL, state.Settings.TokenFile, []string{nibe.ScopeWrite})
-	nibeClient.Verbose = state.Settings.Verbose
+	nibeLog.Info("Creating client")
+	state.nibeClient = newNIBEClient(&state.Settings)
+
+	logSystems(ctx, state.nibeClient, state.Settings.configuredSystems())
+	state.checkSmartHomeModes(ctx, state.Settings.configuredSystems())
 
-	log.Println("Creating Velux client")
-	veluxClient := velux.NewClientWithAuth(state.Settings.Username, state.Settings.Password)
-	veluxClient.Verbose = state.Settings.Verbose
+	veluxLog.Info("Creating client")
+	state.veluxClient = velux.NewClientWithAuth(state.Settings.Username, state.Settings.Password)
+	state.veluxClient.Verbose.Store(state.Settings.Verbose)
+	state.veluxClient.Logger = veluxLog
 
+	var server *http.Server
 	if state.Settings.HTTPPort != 0 {
-		http.HandleFunc("/", state.Handler)
+		state.Settings.HTTPAuth.warnUnprotectedHTML()
+		server = &http.Server{
+			Addr:    fmt.Sprintf(":%d", state.Settings.HTTPPort),
+			Handler: state.routes(),
+		}
+		if state.Settings.TLS.enabled() {
+			server.TLSConfig, err = tlsConfig(&state.Settings.TLS)
+			if err != nil {
+				fatal("Failed to set up TLS", "error", err)
+			}
+		}
 		go func() {
-			if err := http.ListenAndServe(fmt.Sprintf(":%d", state.Settings.HTTPPort), nil); err != nil {
-				log.Fatal(err)
+			var err error
+			if server.TLSConfig != nil {
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				fatal("HTTP server failed", "component", "http", "error", err)
 			}
 		}()
 	}
 
-	ticker := time.NewTicker(time.Duration(state.Settings.PollInterval) * time.Second)
+	// both clients authenticated when they were created
+	notify(sdnotify.Ready, sdnotify.Status("Waiting for the first sync"))
+	state.checkWatchdog()
+
+	var wg sync.WaitGroup
+	for _, loop := range []func(context.Context){
+		state.collectTelemetry,
+		state.watchAlarms,
+		state.watchSyncAge,
+		state.watchConfig,
+	} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			loop(ctx)
+		}()
+	}
 
-	for ; true; <-ticker.C {
-		homeData, err := veluxClient.GetHomesData(velux.GetHomesDataRequest{GatewayTypes: []string{velux.Bridge}})
-		if err != nil {
-			log.Printf("error getting home data: %v", err)
-			continue
+	ticker := time.NewTicker(state.pollInterval())
+	for {
+		err := state.syncOnce(ctx)
+		if ctx.Err() != nil {
+			// interrupted by the shutdown, not a failure
+			break
 		}
-
-		var updates []UpdateResult
-
-		for _, home := range homeData.Body.Homes {
-			roomNames := make(map[string]string)
-			for _, room := range home.Rooms {
-				roomNames[room.ID] = room.Name
-			}
-
-			status, err := veluxClient.HomeStatus(velux.HomeStatusRequest{
-				HomeID:      home.ID,
-				DeviceTypes: []string{velux.Sensor},
-			})
-			if err != nil {
-				log.Printf("error getting home status: %v", err)
-				continue
-			}
-			for _, room := range status.Body.Home.Rooms {
-				roomName, ok := roomNames[room.ID]
-				if !ok {
-					roomName = room.ID
-				}
-
-				log.Printf("Home %s - room %s - temperature %d", home.Name, roomName, room.Temperature)
-				if room.Temperature == 0 {
-					log.Printf("Home %s - room %s - skipping", home.Name, roomName)
-					continue
-				}
-
-				externalId, err := strconv.Atoi(room.ID)
-				if err != nil {
-					log.Printf("Home %s - room %s: failed to parse room ID: %v", home.Name, roomName, err)
-					continue
-				}
-				state.SettingsMu.RLock()
-				temp := state.Settings.TargetTemperature
-				state.SettingsMu.RUnlock()
-				err = nibeClient.SetThermostat(nibe.SetThermostatRequest{
-					SystemID:       state.Settings.System,
-					ExternalId:     externalId % math.MaxInt32, // The NIBE Uplink API doesn't accept values > 2^31
-					Name:           roomName,
-					ActualTemp:     room.Temperature,
-					TargetTemp:     temp,
-					ClimateSystems: []int{1},
-				})
-				updates = append(updates, UpdateResult{
-					Timestamp:         time.Now(),
-					Name:              roomName,
-					ActualTemperature: room.Temperature,
-					TargetTemperature: temp,
-					Result:            err,
-				})
-				if err != nil {
-					log.Printf("Failed to set thermostat %d in room %s: %v", externalId, roomName, err)
-				}
-			}
-			state.UpdatesMu.Lock()
-			state.LastUpdate = updates
-			state.UpdatesMu.Unlock()
+		state.syncFinished(err)
+		// only ping the watchdog when a cycle completes, so systemd restarts
+		// velux-nibe if it hangs
+		notify(sdnotify.Watchdog, sdnotify.Status(state.syncSummary(err)))
+		if !state.waitForNextCycle(ctx, ticker) {
+			break
+		}
+	}
+	ticker.Stop()
+
+	slog.Info("Shutting down")
+	notify(sdnotify.Stopping)
+	if server != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			httpLog.Error("Failed to shut down the HTTP server", "error", err)
 		}
+		cancel()
+	}
+	// wait for the loops so no state is written while the process exits
+	wg.Wait()
+	// deliver the alerts raised until now, e.g. by the last sync
+	alertCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := state.alerts.Close(alertCtx); err != nil {
+		slog.Warn("Failed to deliver all alerts before exiting", "error", err)
 	}
+	cancel()
+	slog.Info("Stopped")
 }
@@ -4,10 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"golang.org/x/oauth2"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/oauth2"
 )
 
 var Endpoint = oauth2.Endpoint{
@@ -20,6 +23,8 @@ const ScopeRead = "READSYSTEM"
 const ScopeWrite = "WRITESYSTEM"
 
 // GetAuthClient retrieves a token, saves it, and returns the generated client.
+// Refreshed tokens are saved as well, so a restart doesn't require the
+// authorization flow again.
 func GetAuthClient(config *oauth2.Config, tokenFileName string) *http.Client {
 	// The file token.json stores the user's access and refresh tokens, and is
 	// created automatically when the authorization flow completes for the first
@@ -27,9 +32,45 @@ func GetAuthClient(config *oauth2.Config, tokenFileName string) *http.Client {
 	tok, err := tokenFromFile(tokenFileName)
 	if err != nil {
 		tok = getTokenFromWeb(config)
-		saveToken(tokenFileName, tok)
+		if err := saveToken(tokenFileName, tok); err != nil {
+			slog.Error("Unable to cache oauth token", "component", "nibe", "error", err)
+			os.Exit(1)
+		}
+	}
+	ctx := context.Background()
+	ts := &savingTokenSource{
+		base: config.TokenSource(ctx, tok),
+		path: tokenFileName,
+		last: tok,
+	}
+	return oauth2.NewClient(ctx, oauth2.ReuseTokenSource(tok, ts))
+}
+
+// savingTokenSource saves tokens to a file whenever they are refreshed.
+type savingTokenSource struct {
+	base oauth2.TokenSource
+	path string
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func (s *savingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil || tok.AccessToken != s.last.AccessToken || tok.RefreshToken != s.last.RefreshToken {
+		// the refreshed token is still usable if it can't be saved
+		if err := saveToken(s.path, tok); err != nil {
+			slog.Warn("Unable to cache oauth token", "component", "nibe", "error", err)
+		}
+		s.last = tok
 	}
-	return config.Client(context.Background(), tok)
+	return tok, nil
 }
 
 // Request a token from the web, then returns the retrieved token.
@@ -40,12 +81,14 @@ func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
 
 	var authCode string
 	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code: %v", err)
+		slog.Error("Unable to read authorization code", "component", "nibe", "error", err)
+		os.Exit(1)
 	}
 
 	tok, err := config.Exchange(context.Background(), authCode)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web: %v", err)
+		slog.Error("Unable to retrieve token from web", "component", "nibe", "error", err)
+		os.Exit(1)
 	}
 	return tok
 }
@@ -62,13 +105,35 @@ func tokenFromFile(file string) (*oauth2.Token, error) {
 	return tok, err
 }
 
-// Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
-	log.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+// Saves a token to a file path. The token is written to a temporary file
+// first and renamed into place, so the file is never left half-written if
+// the process is stopped.
+func saveToken(path string, token *oauth2.Token) error {
+	slog.Info("Saving credential file", "component", "nibe", "path", path)
+	data, err := json.Marshal(token)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		return err
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
 }
@@ -2,15 +2,18 @@ package nibe
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/ingmarstein/velux-nibe/redact"
 	"golang.org/x/oauth2"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 const (
@@ -21,7 +24,11 @@ const (
 type Client struct {
 	BaseURL   *url.URL
 	UserAgent string
-	Verbose   bool
+	// Verbose logs all requests and responses at debug level, with
+	// credentials redacted. It may be changed while requests are in flight.
+	Verbose atomic.Bool
+	// Logger receives the log output of the client (default: slog.Default())
+	Logger *slog.Logger
 
 	client *http.Client
 }
@@ -55,6 +62,13 @@ func NewClient(httpClient *http.Client) *Client {
 	return c
 }
 
+func (c *Client) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.Default()
+}
+
 // NewRequest creates an API request. A relative URL can be provided in urlStr,
 // in which case it is resolved relative to the BaseURL of the Client.
 // Relative URLs should always be specified without a preceding slash. If
@@ -95,10 +109,12 @@ func (c *Client) NewRequest(method, urlStr string, body interface{}) (*http.Requ
 	return req, nil
 }
 
-func (c *Client) do(req *http.Request, v interface{}) (*http.Response, error) {
-	if c.Verbose {
-		if d, err := httputil.DumpRequest(req, true); err == nil {
-			log.Println(string(d))
+func (c *Client) do(ctx context.Context, req *http.Request, v interface{}) (*http.Response, error) {
+	req = req.WithContext(ctx)
+
+	if c.Verbose.Load() {
+		if d, err := redact.DumpRequest(req); err == nil {
+			c.logger().Debug("request", "dump", d)
 		}
 	}
 
@@ -107,19 +123,68 @@ func (c *Client) do(req *http.Request, v interface{}) (*http.Response, error) {
 		return nil, err
 	}
 
-	if c.Verbose {
-		if d, err := httputil.DumpResponse(resp, true); err == nil {
-			log.Println(string(d))
+	if c.Verbose.Load() {
+		if d, err := redact.DumpResponse(resp); err == nil {
+			c.logger().Debug("response", "dump", d)
 		}
 	}
 
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusNoContent {
+	if err := checkResponse(resp); err != nil {
+		return resp, err
+	}
+	if v != nil && resp.StatusCode != http.StatusNoContent {
 		err = json.NewDecoder(resp.Body).Decode(v)
 	}
 	return resp, err
 }
 
+// setOptions set the parameters in opts as URL query parameters to s.
+func setOptions(s string, opts url.Values) (string, error) {
+	if len(opts) == 0 {
+		return s, nil
+	}
+
+	encoded := opts.Encode()
+	if len(encoded) == 0 {
+		return s, nil
+	}
+
+	return s + "?" + encoded, nil
+}
+
+// ErrorResponse reports an error caused by an API request.
+type ErrorResponse struct {
+	// HTTP response that caused this error
+	Response *http.Response
+	// Error code returned by NIBE Uplink, if any
+	Code int `json:"code"`
+	// Error message returned by NIBE Uplink, if any
+	Message string `json:"message"`
+}
+
+func (r *ErrorResponse) Error() string {
+	msg := r.Message
+	if msg == "" {
+		msg = http.StatusText(r.Response.StatusCode)
+	}
+	return fmt.Sprintf("%v %v: %d %s", r.Response.Request.Method, r.Response.Request.URL.Path, r.Response.StatusCode, msg)
+}
+
+// checkResponse returns an *ErrorResponse if the status code of resp does
+// not indicate success.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	errorResponse := &ErrorResponse{Response: resp}
+	data, err := io.ReadAll(resp.Body)
+	if err == nil && len(data) > 0 {
+		_ = json.Unmarshal(data, errorResponse)
+	}
+	return errorResponse
+}
+
 type ImageSize struct {
 	// Width
 	Width int `json:"width"`
@@ -136,9 +201,38 @@ type Image struct {
 	Sizes []ImageSize `json:"sizes"`
 }
 
+// ParameterID identifies a parameter of a NIBE system.
+type ParameterID int
+
+// Well-known parameters which are available on most NIBE heat pumps.
+const (
+	// Outdoor temperature (BT1)
+	ParameterOutdoorTemperature ParameterID = 40004
+	// Supply line temperature (BT2)
+	ParameterSupplyTemperature ParameterID = 40008
+	// Return line temperature (BT3)
+	ParameterReturnTemperature ParameterID = 40012
+	// Hot water top temperature (BT7)
+	ParameterHotWaterTopTemperature ParameterID = 40013
+	// Hot water charging temperature (BT6)
+	ParameterHotWaterChargingTemperature ParameterID = 40014
+	// Degree minutes
+	ParameterDegreeMinutes ParameterID = 43005
+	// Calculated supply temperature of climate system 1
+	ParameterCalculatedSupplyTemperature ParameterID = 43009
+	// Heating curve offset of climate system 1
+	ParameterHeatingOffset ParameterID = 47011
+	// Hot water comfort mode
+	ParameterHotWaterComfortMode ParameterID = 47041
+)
+
+func (id ParameterID) String() string {
+	return strconv.Itoa(int(id))
+}
+
 type Parameter struct {
 	// Parameter id.
-	ParameterID int `json:"parameterId"`
+	ParameterID ParameterID `json:"parameterId"`
 	// Name used for parameter in the request
 	Name string `json:"name"`
 	// Parameter title
@@ -195,7 +289,7 @@ type GetSystemStatusResponse []StatusIconItem
 
 type GetSystemParametersRequest struct {
 	SystemID     int
-	ParameterIDs []string
+	ParameterIDs []ParameterID
 }
 
 type GetSystemParametersResponse []Parameter
@@ -213,14 +307,14 @@ type GetServiceInfoCategoriesResponse []Category
 // does not already exist a thermostat with the supplied id will be created.
 // Even though no change may have occured the thermostat needs to report its
 // current status at least every 30 minutes to continue affecting the system.
-func (c *Client) SetThermostat(request SetThermostatRequest) error {
+func (c *Client) SetThermostat(ctx context.Context, request SetThermostatRequest) error {
 	u := fmt.Sprintf("systems/%d/smarthome/thermostats", request.SystemID)
 
 	req, err := c.NewRequest("POST", u, request)
 	if err != nil {
 		return err
 	}
-	_, err = c.do(req, nil)
+	_, err = c.do(ctx, req, nil)
 	return err
 }
 
@@ -232,42 +326,403 @@ func (c *Client) SetThermostat(request SetThermostatRequest) error {
 // compressors or pumps belonging to this system unit is currently running.
 // For larger systems you need to check each individual system unit's status
 // to get their compressor and pump status.
-func (c *Client) GetSystemStatus(request GetSystemStatusRequest) (GetSystemStatusResponse, error) {
+func (c *Client) GetSystemStatus(ctx context.Context, request GetSystemStatusRequest) (GetSystemStatusResponse, error) {
 	u := fmt.Sprintf("systems/%d/status/system", request.SystemID)
 
-	req, err := c.NewRequest("GET", u, request)
+	req, err := c.NewRequest("GET", u, nil)
 	if err != nil {
 		return nil, err
 	}
 	var response GetSystemStatusResponse
-	_, err = c.do(req, &response)
+	_, err = c.do(ctx, req, &response)
 	return response, err
 }
 
-func (c *Client) GetSystemParameters(request GetSystemParametersRequest) (GetSystemParametersResponse, error) {
-	u := fmt.Sprintf("systems/%d/parameters", request.SystemID)
+// GetSystemParameters returns the current values of the given parameters.
+func (c *Client) GetSystemParameters(ctx context.Context, request GetSystemParametersRequest) (GetSystemParametersResponse, error) {
+	options := url.Values{}
+	for _, id := range request.ParameterIDs {
+		options.Add("parameterIds", id.String())
+	}
 
-	req, err := c.NewRequest("GET", u, request)
+	u, err := setOptions(fmt.Sprintf("systems/%d/parameters", request.SystemID), options)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := c.NewRequest("GET", u, nil)
 	if err != nil {
 		return nil, err
 	}
 	var response GetSystemParametersResponse
-	_, err = c.do(req, &response)
+	_, err = c.do(ctx, req, &response)
 	return response, err
 }
 
-func (c *Client) GetServiceInfoCategories(request GetServiceInfoCategoriesRequest) (GetServiceInfoCategoriesResponse, error) {
-	includeParameters := "false"
-	if request.Parameters {
-		includeParameters = "true"
+// GetServiceInfoCategories returns the service info categories of a system
+// unit, optionally including their parameters.
+func (c *Client) GetServiceInfoCategories(ctx context.Context, request GetServiceInfoCategoriesRequest) (GetServiceInfoCategoriesResponse, error) {
+	options := url.Values{}
+	options.Set("systemUnitId", strconv.Itoa(request.SystemUnitID))
+	options.Set("parameters", strconv.FormatBool(request.Parameters))
+
+	u, err := setOptions(fmt.Sprintf("systems/%d/serviceinfo/categories", request.SystemID), options)
+	if err != nil {
+		return nil, err
 	}
-	u := fmt.Sprintf("systems/%d/serviceinfo/categories?systemUnitId=%d&parameters=%s", request.SystemID, request.SystemUnitID, includeParameters)
 
-	req, err := c.NewRequest("GET", u, request)
+	req, err := c.NewRequest("GET", u, nil)
 	if err != nil {
 		return nil, err
 	}
 	var response GetServiceInfoCategoriesResponse
-	_, err = c.do(req, &response)
+	_, err = c.do(ctx, req, &response)
+	return response, err
+}
+
+type System struct {
+	// System id
+	SystemID int `json:"systemId"`
+	// Name of the system
+	Name string `json:"name"`
+	// Product name of the main unit
+	ProductName string `json:"productName"`
+	// Security level of the current user for this system, e.g. ADMIN
+	SecurityLevel string `json:"securityLevel"`
+	// Serial number of the main unit
+	SerialNumber string `json:"serialNumber"`
+	// Last time the system communicated with NIBE Uplink
+	LastActivityDate string `json:"lastActivityDate"`
+	// ONLINE, OFFLINE or NONE
+	ConnectionStatus string `json:"connectionStatus"`
+	// Whether the system currently has an active alarm
+	HasAlarmed bool `json:"hasAlarmed"`
+}
+
+type GetSystemsRequest struct {
+	// Page number, starting at 1
+	Page int
+	// Number of systems per page, at most 100
+	ItemsPerPage int
+}
+
+type GetSystemsResponse struct {
+	Page         int      `json:"page"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	NumItems     int      `json:"numItems"`
+	Objects      []System `json:"objects"`
+}
+
+// GetSystems returns a page of the systems the current user has access to.
+// Use NumItems of the response to determine whether more pages are available.
+func (c *Client) GetSystems(ctx context.Context, request GetSystemsRequest) (GetSystemsResponse, error) {
+	page := request.Page
+	if page < 1 {
+		page = 1
+	}
+	itemsPerPage := request.ItemsPerPage
+	if itemsPerPage < 1 {
+		itemsPerPage = 30
+	}
+	options := url.Values{}
+	options.Set("page", strconv.Itoa(page))
+	options.Set("itemsPerPage", strconv.Itoa(itemsPerPage))
+
+	u, err := setOptions("systems", options)
+	if err != nil {
+		return GetSystemsResponse{}, err
+	}
+
+	req, err := c.NewRequest("GET", u, nil)
+	if err != nil {
+		return GetSystemsResponse{}, err
+	}
+	var response GetSystemsResponse
+	_, err = c.do(ctx, req, &response)
+	return response, err
+}
+
+// GetAllSystems returns all systems the current user has access to by
+// requesting every page of GetSystems.
+func (c *Client) GetAllSystems(ctx context.Context) ([]System, error) {
+	var systems []System
+	for page := 1; ; page++ {
+		response, err := c.GetSystems(ctx, GetSystemsRequest{Page: page, ItemsPerPage: 100})
+		if err != nil {
+			return nil, err
+		}
+		systems = append(systems, response.Objects...)
+		if len(response.Objects) == 0 || len(systems) >= response.NumItems {
+			return systems, nil
+		}
+	}
+}
+
+type GetSystemRequest struct {
+	SystemID int
+}
+
+// GetSystem returns information about a single system.
+func (c *Client) GetSystem(ctx context.Context, request GetSystemRequest) (System, error) {
+	u := fmt.Sprintf("systems/%d", request.SystemID)
+
+	req, err := c.NewRequest("GET", u, nil)
+	if err != nil {
+		return System{}, err
+	}
+	var response System
+	_, err = c.do(ctx, req, &response)
+	return response, err
+}
+
+type SystemUnit struct {
+	// System unit id, 0 for the main unit
+	SystemUnitID int `json:"systemUnitId"`
+	// Name of the system unit
+	Name string `json:"name"`
+	// Product name
+	Product string `json:"product"`
+}
+
+type GetSystemUnitsRequest struct {
+	SystemID int
+}
+
+type GetSystemUnitsResponse []SystemUnit
+
+// GetSystemUnits returns the units, i.e. the main unit and any slave units,
+// that make up a system.
+func (c *Client) GetSystemUnits(ctx context.Context, request GetSystemUnitsRequest) (GetSystemUnitsResponse, error) {
+	u := fmt.Sprintf("systems/%d/units", request.SystemID)
+
+	req, err := c.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	var response GetSystemUnitsResponse
+	_, err = c.do(ctx, req, &response)
+	return response, err
+}
+
+type SmartHomeMode string
+
+const (
+	SmartHomeModeDefault  SmartHomeMode = "DEFAULT_OPERATION"
+	SmartHomeModeAway     SmartHomeMode = "AWAY_FROM_HOME"
+	SmartHomeModeVacation SmartHomeMode = "VACATION"
+	SmartHomeModeHome     SmartHomeMode = "HOME"
+)
+
+// SmartHomeModes lists all known smart home modes.
+var SmartHomeModes = []SmartHomeMode{SmartHomeModeDefault, SmartHomeModeAway, SmartHomeModeVacation, SmartHomeModeHome}
+
+// Valid reports whether m is a known smart home mode.
+func (m SmartHomeMode) Valid() bool {
+	for _, mode := range SmartHomeModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+type GetSmartHomeModeRequest struct {
+	SystemID int
+}
+
+type GetSmartHomeModeResponse struct {
+	Mode SmartHomeMode `json:"mode"`
+}
+
+// GetSmartHomeMode returns the current smart home mode of the system.
+func (c *Client) GetSmartHomeMode(ctx context.Context, request GetSmartHomeModeRequest) (GetSmartHomeModeResponse, error) {
+	u := fmt.Sprintf("systems/%d/smarthome/mode", request.SystemID)
+
+	req, err := c.NewRequest("GET", u, nil)
+	if err != nil {
+		return GetSmartHomeModeResponse{}, err
+	}
+	var response GetSmartHomeModeResponse
+	_, err = c.do(ctx, req, &response)
+	return response, err
+}
+
+type SetSmartHomeModeRequest struct {
+	SystemID int           `json:"-"`
+	Mode     SmartHomeMode `json:"mode"`
+}
+
+// SetSmartHomeMode changes the smart home mode of the system.
+func (c *Client) SetSmartHomeMode(ctx context.Context, request SetSmartHomeModeRequest) error {
+	u := fmt.Sprintf("systems/%d/smarthome/mode", request.SystemID)
+
+	req, err := c.NewRequest("PUT", u, request)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(ctx, req, nil)
+	return err
+}
+
+type Thermostat struct {
+	// Id number set by the smart home system
+	ExternalID int `json:"externalId"`
+	// Name of the smart home system which reported the thermostat
+	SmartHomeSystem string `json:"smartHomeSystem"`
+	// Human readable name for the thermostat
+	Name string `json:"name"`
+	// List of climate systems this thermostat affects
+	ClimateSystems []int `json:"climateSystems"`
+	// Last reported temperature in deg. Celsius, multiplied by 10, if any
+	CurrentTemperature *int `json:"currentTemperature"`
+	// Last reported target temperature in deg. Celsius, multiplied by 10, if any
+	TargetTemperature *int `json:"targetTemperature"`
+	// Last reported valve position in percent, if any
+	ValvePosition *int `json:"valvePosition"`
+	// Time of the last update
+	LastUpdate string `json:"lastUpdate"`
+}
+
+type GetThermostatsRequest struct {
+	SystemID int
+}
+
+type GetThermostatsResponse []Thermostat
+
+// GetThermostats returns the smart home thermostats known to the system.
+func (c *Client) GetThermostats(ctx context.Context, request GetThermostatsRequest) (GetThermostatsResponse, error) {
+	u := fmt.Sprintf("systems/%d/smarthome/thermostats", request.SystemID)
+
+	req, err := c.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	var response GetThermostatsResponse
+	_, err = c.do(ctx, req, &response)
+	return response, err
+}
+
+type SetSystemParametersRequest struct {
+	SystemID int `json:"-"`
+	// New raw values, keyed by parameter id
+	Settings map[ParameterID]int `json:"settings"`
+}
+
+// ParameterSetStatus describes the outcome of changing a parameter.
+type ParameterSetStatus string
+
+const (
+	// The new value has been applied by the system
+	ParameterSetDone ParameterSetStatus = "DONE"
+	// The new value has been accepted by NIBE Uplink but not yet been
+	// applied by the system
+	ParameterSetScheduled ParameterSetStatus = "SCHEDULED"
+	// The new value has been rejected
+	ParameterSetFailed ParameterSetStatus = "FAILED"
+)
+
+// Pending reports whether the change has not been applied yet.
+func (s ParameterSetStatus) Pending() bool {
+	return s == ParameterSetScheduled
+}
+
+// Failed reports whether the change has been rejected.
+func (s ParameterSetStatus) Failed() bool {
+	return s != ParameterSetDone && s != ParameterSetScheduled
+}
+
+type ParameterSetResult struct {
+	// Status of the change
+	Status ParameterSetStatus `json:"status"`
+	// Parameter after the change
+	Parameter Parameter `json:"parameter"`
+}
+
+type SetSystemParametersResponse []ParameterSetResult
+
+// SetSystemParameters changes the raw value of one or more parameters. The
+// response contains one result per parameter; changes may be applied
+// asynchronously, in which case they are reported as pending.
+func (c *Client) SetSystemParameters(ctx context.Context, request SetSystemParametersRequest) (SetSystemParametersResponse, error) {
+	u := fmt.Sprintf("systems/%d/parameters", request.SystemID)
+
+	req, err := c.NewRequest("PUT", u, request)
+	if err != nil {
+		return nil, err
+	}
+	var response SetSystemParametersResponse
+	_, err = c.do(ctx, req, &response)
+	return response, err
+}
+
+const (
+	NotificationTypeAlarm = "ALARM"
+	NotificationTypeInfo  = "INFO"
+)
+
+type Notification struct {
+	// Notification id
+	NotificationID int `json:"notificationId"`
+	// Time the notification was raised
+	CreatedAt string `json:"createdAt"`
+	// Severity, higher is more severe
+	Severity int `json:"severity"`
+	// Alarm number as shown on the display of the heat pump
+	AlarmNumber int `json:"alarmNumber"`
+	// ALARM or INFO
+	Type string `json:"type"`
+	// Short description
+	Header string `json:"header"`
+	// Long description
+	Description string `json:"description"`
+	// Name of the equipment which raised the notification
+	EquipName string `json:"equipName"`
+}
+
+type GetNotificationsRequest struct {
+	SystemID int
+	// Only return active notifications
+	Active bool
+	// Only return notifications of this type, e.g. NotificationTypeAlarm
+	NotificationType string
+	// Page number, starting at 1
+	Page int
+	// Number of notifications per page, at most 100
+	ItemsPerPage int
+}
+
+type GetNotificationsResponse struct {
+	Page         int            `json:"page"`
+	ItemsPerPage int            `json:"itemsPerPage"`
+	NumItems     int            `json:"numItems"`
+	Objects      []Notification `json:"objects"`
+}
+
+// GetNotifications returns a page of the notifications, e.g. alarms, of the
+// system.
+func (c *Client) GetNotifications(ctx context.Context, request GetNotificationsRequest) (GetNotificationsResponse, error) {
+	options := url.Values{}
+	options.Set("active", strconv.FormatBool(request.Active))
+	if request.NotificationType != "" {
+		options.Set("notificationType", request.NotificationType)
+	}
+	if request.Page > 0 {
+		options.Set("page", strconv.Itoa(request.Page))
+	}
+	if request.ItemsPerPage > 0 {
+		options.Set("itemsPerPage", strconv.Itoa(request.ItemsPerPage))
+	}
+
+	u, err := setOptions(fmt.Sprintf("systems/%d/notifications", request.SystemID), options)
+	if err != nil {
+		return GetNotificationsResponse{}, err
+	}
+
+	req, err := c.NewRequest("GET", u, nil)
+	if err != nil {
+		return GetNotificationsResponse{}, err
+	}
+	var response GetNotificationsResponse
+	_, err = c.do(ctx, req, &response)
 	return response, err
 }
@@ -0,0 +1,130 @@
+package nibe
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/ingmarstein/velux-nibe/replay"
+	"golang.org/x/oauth2"
+)
+
+// Run with -record to record the fixtures using the credentials in
+// $NIBE_CLIENT_ID, $NIBE_CLIENT_SECRET, $NIBE_CALLBACK_URL and the token in
+// $NIBE_TOKEN. The tests expect system 12345, see the README.
+var record = flag.Bool("record", false, "record fixtures from the NIBE Uplink API")
+
+// newTestClient returns a client replaying the interactions in the given
+// fixture, or recording them with -record.
+func newTestClient(t *testing.T, fixture string) *Client {
+	t.Helper()
+	return NewClient(replay.ForTest(t, "testdata/"+fixture+".json", *record, func() http.RoundTripper {
+		conf := &oauth2.Config{
+			ClientID:     os.Getenv("NIBE_CLIENT_ID"),
+			ClientSecret: os.Getenv("NIBE_CLIENT_SECRET"),
+			Endpoint:     Endpoint,
+			RedirectURL:  os.Getenv("NIBE_CALLBACK_URL"),
+			Scopes:       []string{ScopeWrite},
+		}
+		return GetAuthClient(conf, os.Getenv("NIBE_TOKEN")).Transport
+	}))
+}
+
+func TestClient(t *testing.T) {
+	c := newTestClient(t, "client")
+	ctx := context.Background()
+
+	t.Run("GetAllSystems", func(t *testing.T) {
+		systems, err := c.GetAllSystems(ctx)
+		if err != nil {
+			t.Fatalf("GetAllSystems: %v", err)
+		}
+		if len(systems) != 1 {
+			t.Fatalf("got %d systems, want 1", len(systems))
+		}
+		if s := systems[0]; s.SystemID != 12345 || s.ProductName != "NIBE F1255" || s.ConnectionStatus != "ONLINE" {
+			t.Errorf("got system %+v, want 12345 (NIBE F1255, ONLINE)", s)
+		}
+	})
+
+	t.Run("SetThermostat", func(t *testing.T) {
+		err := c.SetThermostat(ctx, SetThermostatRequest{
+			SystemID:       12345,
+			ExternalId:     1,
+			Name:           "Living room",
+			ActualTemp:     215,
+			TargetTemp:     210,
+			ClimateSystems: []int{1},
+		})
+		if err != nil {
+			t.Errorf("SetThermostat: %v", err)
+		}
+	})
+
+	t.Run("GetThermostats", func(t *testing.T) {
+		thermostats, err := c.GetThermostats(ctx, GetThermostatsRequest{SystemID: 12345})
+		if err != nil {
+			t.Fatalf("GetThermostats: %v", err)
+		}
+		if len(thermostats) != 2 {
+			t.Fatalf("got %d thermostats, want 2", len(thermostats))
+		}
+		if th := thermostats[0]; th.ExternalID != 1 || th.CurrentTemperature == nil || *th.CurrentTemperature != 215 {
+			t.Errorf("got thermostat %+v, want 1 with 21.5 °C", th)
+		}
+		if th := thermostats[1]; th.ExternalID != 7 || th.CurrentTemperature != nil || th.ValvePosition != nil {
+			t.Errorf("got thermostat %+v, want 7 without readings", th)
+		}
+	})
+
+	t.Run("SetSystemParameters", func(t *testing.T) {
+		results, err := c.SetSystemParameters(ctx, SetSystemParametersRequest{
+			SystemID: 12345,
+			Settings: map[ParameterID]int{ParameterHeatingOffset: 1},
+		})
+		if err != nil {
+			t.Fatalf("SetSystemParameters: %v", err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("got %d results, want 1", len(results))
+		}
+		if r := results[0]; r.Status.Failed() || r.Status.Pending() || r.Parameter.ParameterID != ParameterHeatingOffset || r.Parameter.RawValue != 1 {
+			t.Errorf("got result %+v, want parameter 47011 set to 1", r)
+		}
+	})
+
+	t.Run("GetNotifications", func(t *testing.T) {
+		notifications, err := c.GetNotifications(ctx, GetNotificationsRequest{
+			SystemID:         12345,
+			Active:           true,
+			NotificationType: NotificationTypeAlarm,
+			ItemsPerPage:     100,
+		})
+		if err != nil {
+			t.Fatalf("GetNotifications: %v", err)
+		}
+		if len(notifications.Objects) != 1 {
+			t.Fatalf("got %d notifications, want 1", len(notifications.Objects))
+		}
+		if n := notifications.Objects[0]; n.AlarmNumber != 163 || n.Type != NotificationTypeAlarm || n.EquipName != "EB100" {
+			t.Errorf("got notification %+v, want alarm 163 of EB100", n)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		_, err := c.GetSystem(ctx, GetSystemRequest{SystemID: 99999})
+		var errorResponse *ErrorResponse
+		if !errors.As(err, &errorResponse) {
+			t.Fatalf("got error %v, want *ErrorResponse", err)
+		}
+		if errorResponse.Response.StatusCode != http.StatusForbidden || errorResponse.Message != "Access to system 99999 denied" {
+			t.Errorf("got %d %q, want 403 and the message of NIBE Uplink", errorResponse.Response.StatusCode, errorResponse.Message)
+		}
+		if want := "GET /api/v1/systems/99999: 403 Access to system 99999 denied"; err.Error() != want {
+			t.Errorf("got error %q, want %q", err, want)
+		}
+	})
+}
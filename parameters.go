@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/ingmarstein/velux-nibe/nibe"
+)
+
+// setParameters changes the given NIBE parameters of a configured system.
+// Only parameters listed in WritableParameters may be changed.
+func setParameters(ctx context.Context, client *nibe.Client, settings *SystemSettings, system int, values map[nibe.ParameterID]int) (nibe.SetSystemParametersResponse, error) {
+	if !slices.Contains(settings.configuredSystems(), system) {
+		return nil, invalidRequest("NIBE system %d is not configured", system)
+	}
+	if len(values) == 0 {
+		return nil, invalidRequest("no parameters given")
+	}
+	for parameter := range values {
+		if !slices.Contains(settings.WritableParameters, parameter) {
+			return nil, invalidRequest("parameter %d is not writable, add it to nibe_writable_parameters to allow changing it", parameter)
+		}
+	}
+
+	results, err := client.SetSystemParameters(ctx, nibe.SetSystemParametersRequest{SystemID: system, Settings: values})
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		nibeLog.Info("Parameter set", "system", system, "parameter", result.Parameter.ParameterID, "title", result.Parameter.Title, "value", result.Parameter.DisplayValue, "status", result.Status)
+	}
+	return results, nil
+}
+
+// ParametersAPIHandler handles PUT requests for
+// /api/systems/{system}/parameters. The request body maps parameter IDs to
+// new raw values, e.g. {"47011": 1}.
+func (state *SystemState) ParametersAPIHandler(w http.ResponseWriter, r *http.Request) {
+	system, err := strconv.Atoi(r.PathValue("system"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid system: %w", err))
+		return
+	}
+	var values map[nibe.ParameterID]int
+	if err := json.NewDecoder(r.Body).Decode(&values); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	state.SettingsMu.RLock()
+	settings := state.Settings
+	state.SettingsMu.RUnlock()
+
+	results, err := setParameters(r.Context(), state.nibeClient, &settings, system, values)
+	if err != nil {
+		writeJSONError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, results)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ingmarstein/velux-nibe/nibe"
+)
+
+func TestParametersAPIHandlerStatus(t *testing.T) {
+	// stands in for NIBE Uplink, which fails every request
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"code":500,"message":"Internal error"}`, http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+	client := nibe.NewClient(upstream.Client())
+	client.BaseURL, _ = url.Parse(upstream.URL + "/")
+
+	state := &SystemState{
+		Settings: SystemSettings{
+			System:             12345,
+			WritableParameters: []nibe.ParameterID{47011},
+		},
+		nibeClient: client,
+	}
+
+	tests := []struct {
+		system, body string
+		want         int
+	}{
+		{"12345", `{"47011": 1}`, http.StatusBadGateway},
+		{"12345", `{"47012": 1}`, http.StatusBadRequest},
+		{"12345", `{}`, http.StatusBadRequest},
+		{"67890", `{"47011": 1}`, http.StatusBadRequest},
+		{"12345", `not json`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("PUT", "/api/systems/"+tt.system+"/parameters", strings.NewReader(tt.body))
+		req.SetPathValue("system", tt.system)
+		rec := httptest.NewRecorder()
+		state.ParametersAPIHandler(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("PUT %s to system %s: got status %d, want %d (%s)", tt.body, tt.system, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
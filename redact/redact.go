@@ -0,0 +1,48 @@
+// Package redact removes credentials such as passwords, client secrets and
+// OAuth tokens from HTTP dumps before they are logged.
+package redact
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"regexp"
+)
+
+// Replacement is inserted in place of redacted values.
+const Replacement = "[REDACTED]"
+
+var (
+	// headers carrying credentials
+	headers = regexp.MustCompile(`(?im)^(Authorization|Proxy-Authorization|Cookie|Set-Cookie):[^\r\n]*`)
+	// URL query and form encoded parameters
+	params = regexp.MustCompile(`(?i)\b(password|client_secret|access_token|refresh_token|code|token)=[^&\s]*`)
+	// JSON string fields
+	fields = regexp.MustCompile(`(?i)"(password|client_secret|access_token|refresh_token|token)"(\s*:\s*)"(?:[^"\\]|\\.)*"`)
+)
+
+// Dump redacts credentials in an HTTP request or response dump.
+func Dump(dump []byte) []byte {
+	dump = headers.ReplaceAll(dump, []byte("$1: "+Replacement))
+	dump = params.ReplaceAll(dump, []byte("$1="+Replacement))
+	dump = fields.ReplaceAll(dump, []byte(`"$1"$2"`+Replacement+`"`))
+	return dump
+}
+
+// DumpRequest returns the redacted dump of an outgoing request including its
+// body.
+func DumpRequest(req *http.Request) (string, error) {
+	dump, err := httputil.DumpRequestOut(req, true)
+	if err != nil {
+		return "", err
+	}
+	return string(Dump(dump)), nil
+}
+
+// DumpResponse returns the redacted dump of a response including its body.
+func DumpResponse(resp *http.Response) (string, error) {
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return "", err
+	}
+	return string(Dump(dump)), nil
+}
@@ -0,0 +1,84 @@
+package redact
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			"headers",
+			"GET / HTTP/1.1\r\nHost: example.com\r\nAuthorization: Bearer secret-token\r\ncookie: session=secret\r\n\r\n",
+			"GET / HTTP/1.1\r\nHost: example.com\r\nAuthorization: [REDACTED]\r\ncookie: [REDACTED]\r\n\r\n",
+		},
+		{
+			"response headers",
+			"HTTP/1.1 200 OK\r\nSet-Cookie: id=secret; Path=/\r\nContent-Type: text/plain\r\n\r\n",
+			"HTTP/1.1 200 OK\r\nSet-Cookie: [REDACTED]\r\nContent-Type: text/plain\r\n\r\n",
+		},
+		{
+			"form",
+			"grant_type=password&username=user%40example.com&password=p%40ss&client_secret=secret-value",
+			"grant_type=password&username=user%40example.com&password=[REDACTED]&client_secret=[REDACTED]",
+		},
+		{
+			"query",
+			"https://api.example.com/callback?code=secret-code&state=xyz&access_token=secret-token",
+			"https://api.example.com/callback?code=[REDACTED]&state=xyz&access_token=[REDACTED]",
+		},
+		{
+			"json",
+			`{"access_token":"secret-token","expires_in":10800,"refresh_token" : "secret-refresh","scope":["read"]}`,
+			`{"access_token":"[REDACTED]","expires_in":10800,"refresh_token" : "[REDACTED]","scope":["read"]}`,
+		},
+		{
+			"json with escaped quotes",
+			`{"password":"se\"cr\\et\"","user":"a \"quoted\" name"}`,
+			`{"password":"[REDACTED]","user":"a \"quoted\" name"}`,
+		},
+		{
+			"json without credentials",
+			`{"status":"ok","body":{"homes":[{"id":"home"}]}}`,
+			`{"status":"ok","body":{"homes":[{"id":"home"}]}}`,
+		},
+		{
+			"similar names",
+			"encoding=gzip&tokens=5&mycode=1",
+			"encoding=gzip&tokens=5&mycode=1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(Dump([]byte(tt.in))); got != tt.want {
+				t.Errorf("Dump(%q)\ngot  %q\nwant %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDumpRequest(t *testing.T) {
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/token?access_token=secret-query",
+		strings.NewReader("username=user&password=secret-password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Basic secret-basic")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	dump, err := DumpRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(dump, "secret-") {
+		t.Errorf("dump contains credentials:\n%s", dump)
+	}
+	for _, want := range []string{"POST /oauth2/token?access_token=[REDACTED] HTTP/1.1", "Authorization: [REDACTED]", "username=user&password=[REDACTED]"} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("dump lacks %q:\n%s", want, dump)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/ingmarstein/velux-nibe/alert"
+	"github.com/ingmarstein/velux-nibe/sdnotify"
+)
+
+// configCheckInterval defines how often the config file is checked for
+// changes.
+const configCheckInterval = 5 * time.Second
+
+// watchConfig reloads the settings whenever the config file changes or the
+// process receives SIGHUP.
+func (state *SystemState) watchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var lastMod time.Time
+	if *configFile != "" {
+		if fi, err := os.Stat(*configFile); err == nil {
+			lastMod = fi.ModTime()
+		}
+	}
+
+	ticker := time.NewTicker(configCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("Received SIGHUP, reloading settings")
+		case <-ticker.C:
+			if *configFile == "" {
+				continue
+			}
+			fi, err := os.Stat(*configFile)
+			if err != nil || fi.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = fi.ModTime()
+			slog.Info("Config file changed, reloading settings")
+		}
+		state.reloadSettings(ctx)
+	}
+}
+
+// reloadSettings reads and validates the settings and applies them. If they
+// are invalid, the current settings are kept.
+func (state *SystemState) reloadSettings(ctx context.Context) {
+	settings, err := readSettings()
+	if err == nil {
+		err = settings.Validate()
+	}
+	var notifiers []alert.Notifier
+	if err == nil {
+		notifiers, err = settings.Alerts.Notifiers()
+	}
+	if err != nil {
+		slog.Error("Failed to reload settings, keeping the current ones", "error", err)
+		state.SettingsMu.Lock()
+		state.ConfigError = err.Error()
+		state.SettingsMu.Unlock()
+		return
+	}
+
+	state.SettingsMu.Lock()
+	old := state.Settings
+	keepRestartOnlySettings(&old, &settings)
+	state.setSettings(settings)
+	settings = state.Settings
+	state.ConfigError = ""
+	state.SettingsMu.Unlock()
+
+	state.alerts.SetNotifiers(notifiers...)
+	if settings.HTTPPort != 0 && !reflect.DeepEqual(old.HTTPAuth, settings.HTTPAuth) {
+		settings.HTTPAuth.warnUnprotectedHTML()
+	}
+	level, _ := settings.logLevel()
+	logLevel.Set(level)
+	if state.nibeClient != nil {
+		state.nibeClient.Verbose.Store(settings.Verbose)
+	}
+	if state.veluxClient != nil {
+		state.veluxClient.Verbose.Store(settings.Verbose)
+	}
+	if old.PollInterval != settings.PollInterval {
+		slog.Info("Poll interval changed", "old", old.PollInterval, "new", settings.PollInterval)
+		state.checkWatchdog()
+		select {
+		case state.intervalChanged <- struct{}{}:
+		default:
+		}
+	}
+	if state.nibeClient != nil && !slices.Equal(old.configuredSystems(), settings.configuredSystems()) {
+		state.refreshSmartHomeModes(ctx, settings.configuredSystems())
+	}
+	slog.Info("Settings reloaded")
+}
+
+// keepRestartOnlySettings copies the settings which only take effect on
+// startup from old to new and logs the ones which were changed.
+func keepRestartOnlySettings(old, new *SystemSettings) {
+	restartOnly := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"velux_user", &old.Username, &new.Username},
+		{"velux_password", &old.Password, &new.Password},
+		{"nibe_client_id", &old.ClientID, &new.ClientID},
+		{"nibe_client_secret", &old.ClientSecret, &new.ClientSecret},
+		{"nibe_callback", &old.CallbackURL, &new.CallbackURL},
+		{"nibe_token", &old.TokenFile, &new.TokenFile},
+		{"nibe_id_map", &old.IDMapFile, &new.IDMapFile},
+		{"state_file", &old.StateFile, &new.StateFile},
+		{"http_port", &old.HTTPPort, &new.HTTPPort},
+		{"tls", &old.TLS, &new.TLS},
+		{"log_format", &old.LogFormat, &new.LogFormat},
+		{"history_dir", &old.HistoryDir, &new.HistoryDir},
+		{"history_retention", &old.HistoryRetention, &new.HistoryRetention},
+	}
+	for _, s := range restartOnly {
+		o, n := reflect.ValueOf(s.old).Elem(), reflect.ValueOf(s.new).Elem()
+		if !reflect.DeepEqual(o.Interface(), n.Interface()) {
+			slog.Warn("Changing this setting requires a restart", "setting", s.name)
+			n.Set(o)
+		}
+	}
+}
+
+// checkWatchdog warns if the systemd watchdog could expire between two sync
+// cycles. systemd recommends pinging it every half of the timeout.
+func (state *SystemState) checkWatchdog() {
+	if timeout, ok := sdnotify.WatchdogInterval(); ok && state.pollInterval() > timeout/2 {
+		slog.Warn("The poll interval is longer than half the systemd watchdog timeout, increase WatchdogSec",
+			"timeout", timeout, "interval", state.pollInterval())
+	}
+}
+
+// pollInterval returns the current poll interval.
+func (state *SystemState) pollInterval() time.Duration {
+	state.SettingsMu.RLock()
+	defer state.SettingsMu.RUnlock()
+	return time.Duration(state.Settings.PollInterval) * time.Second
+}
+
+// waitForNextCycle blocks until the next sync cycle is due and reports
+// whether it is, or false if ctx is done. If the poll interval changes in the
+// meantime, the ticker is adjusted.
+func (state *SystemState) waitForNextCycle(ctx context.Context, ticker *time.Ticker) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			return true
+		case <-state.intervalChanged:
+			ticker.Reset(state.pollInterval())
+		}
+	}
+}
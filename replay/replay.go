@@ -0,0 +1,223 @@
+// Package replay implements an http.RoundTripper which records interactions
+// with an HTTP API to a fixture file and replays them later, so API clients
+// can be tested without network access or credentials. Credentials are
+// scrubbed from the fixtures using the redact package.
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ingmarstein/velux-nibe/redact"
+)
+
+// Request is the recorded part of an HTTP request.
+type Request struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+// Response is the recorded part of an HTTP response.
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Interaction is a request and the response it received.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Transport records or replays HTTP interactions.
+//
+// In replay mode, every request is answered with the response of the first
+// unused recorded interaction with the same method, URL and body. Requests
+// without a matching interaction fail.
+//
+// In record mode, requests are sent using the underlying transport and
+// recorded until Save is called.
+type Transport struct {
+	path   string
+	record bool
+	base   http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+	used         []bool
+}
+
+// New returns a transport which replays the interactions stored in path. If
+// record is set, requests are sent using base (default:
+// http.DefaultTransport) instead and recorded to path by Save.
+func New(path string, record bool, base http.RoundTripper) (*Transport, error) {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	t := &Transport{path: path, record: record, base: base}
+	if record {
+		return t, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &t.interactions); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	t.used = make([]bool, len(t.interactions))
+	return t, nil
+}
+
+// Client returns an http.Client using the transport.
+func (t *Transport) Client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	recorded := scrubRequest(req, body)
+
+	if t.record {
+		// a RoundTripper must not modify the request, so the body which was
+		// read is sent with a copy
+		out := req.Clone(req.Context())
+		if body != nil {
+			out.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		return t.recordInteraction(out, recorded)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, interaction := range t.interactions {
+		if !t.used[i] && interaction.Request == recorded {
+			t.used[i] = true
+			return interaction.Response.httpResponse(req), nil
+		}
+	}
+	return nil, fmt.Errorf("replay: no recorded interaction for %s %s in %s", recorded.Method, recorded.URL, t.path)
+}
+
+func (t *Transport) recordInteraction(req *http.Request, recorded Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	// the length changes when the body is scrubbed
+	header := resp.Header.Clone()
+	for _, name := range []string{"Set-Cookie", "Date", "Content-Length"} {
+		header.Del(name)
+	}
+
+	t.mu.Lock()
+	t.interactions = append(t.interactions, Interaction{
+		Request: recorded,
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     header,
+			Body:       string(redact.Dump(data)),
+		},
+	})
+	t.mu.Unlock()
+	return resp, nil
+}
+
+// Unused returns the recorded interactions which were not replayed. It
+// returns nil in record mode.
+func (t *Transport) Unused() []Interaction {
+	if t.record {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var unused []Interaction
+	for i, interaction := range t.interactions {
+		if !t.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+	return unused
+}
+
+// Save writes the recorded interactions to the fixture file. It does nothing
+// in replay mode.
+func (t *Transport) Save() error {
+	if !t.record {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	t.mu.Lock()
+	err := enc.Encode(t.interactions)
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(t.path, buf.Bytes(), 0644)
+}
+
+// readBody reads and closes the body of req.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// scrubRequest returns the recorded form of req, with credentials redacted.
+func scrubRequest(req *http.Request, body []byte) Request {
+	return Request{
+		Method: req.Method,
+		URL:    string(redact.Dump([]byte(req.URL.String()))),
+		Body:   string(redact.Dump(body)),
+	}
+}
+
+func (r Response) httpResponse(req *http.Request) *http.Response {
+	header := r.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader([]byte(r.Body))),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
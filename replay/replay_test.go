@@ -0,0 +1,107 @@
+package replay
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server receives the body which was read for the fixture
+		if r.PostFormValue("password") != "secret-password" {
+			t.Errorf("server got form %v", r.PostForm)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret-cookie")
+		io.WriteString(w, `{"access_token":"secret-access","refresh_token":"secret-refresh","expires_in":10800}`)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	form := url.Values{"grant_type": {"password"}, "username": {"user"}, "password": {"secret-password"}, "client_secret": {"secret-client"}}
+
+	recorder, err := New(path, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := recorder.Client().PostForm(server.URL+"/oauth2/token", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordedBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(recordedBody), "secret-access") {
+		t.Errorf("recording changed the response seen by the client: %s", recordedBody)
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	fixture, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(fixture), "secret-") {
+		t.Errorf("fixture contains credentials:\n%s", fixture)
+	}
+
+	player, err := New(path, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the request matches although the live credentials differ from the
+	// scrubbed ones
+	form.Set("password", "other-password")
+	resp, err = player.Client().PostForm(server.URL+"/oauth2/token", form)
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("got status %d and content type %q, want 200 and application/json", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if want := `{"access_token":"[REDACTED]","refresh_token":"[REDACTED]","expires_in":10800}`; string(body) != want {
+		t.Errorf("got body %s, want %s", body, want)
+	}
+	if unused := player.Unused(); len(unused) != 0 {
+		t.Errorf("got %d unused interactions, want 0", len(unused))
+	}
+
+	// every interaction is replayed only once
+	if _, err := player.Client().PostForm(server.URL+"/oauth2/token", form); err == nil {
+		t.Error("replaying an interaction twice succeeded")
+	}
+}
+
+func TestReplayUnknownRequest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	if err := os.WriteFile(path, []byte(`[{"request":{"method":"GET","url":"https://example.com/a"},"response":{"status_code":204}}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	player, err := New(path, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := player.Client().Post("https://example.com/a", "text/plain", nil); err == nil {
+		t.Error("request with a different method was replayed")
+	}
+	if _, err := player.Client().Get("https://example.com/b"); err == nil {
+		t.Error("request with a different URL was replayed")
+	}
+	resp, err := player.Client().Get("https://example.com/a")
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("got status %d, want 204", resp.StatusCode)
+	}
+}
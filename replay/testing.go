@@ -0,0 +1,33 @@
+package replay
+
+import (
+	"net/http"
+	"testing"
+)
+
+// ForTest returns a client replaying the interactions stored in path, or
+// recording them using the transport returned by base if record is set. base
+// is only called when recording, so tests don't need credentials otherwise.
+// When the test finishes, the recorded interactions are saved, and the test
+// fails if any of the replayed ones were not used.
+func ForTest(t testing.TB, path string, record bool, base func() http.RoundTripper) *http.Client {
+	t.Helper()
+
+	var transport http.RoundTripper
+	if record {
+		transport = base()
+	}
+	r, err := New(path, record, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := r.Save(); err != nil {
+			t.Errorf("failed to save fixture: %v", err)
+		}
+		if unused := r.Unused(); len(unused) > 0 {
+			t.Errorf("%s: %d recorded interactions were not replayed, first: %s %s", path, len(unused), unused[0].Request.Method, unused[0].Request.URL)
+		}
+	})
+	return r.Client()
+}
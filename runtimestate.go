@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultAwayTargetTemperature is sent for all rooms in away mode unless
+// away_target_temperature is set.
+const defaultAwayTargetTemperature = 160
+
+// RuntimeState holds the settings changed through the HTML interface and the
+// alarms which were already reported. It is stored in a separate state file
+// and merged over the settings from the config file, so the config file is
+// never rewritten.
+type RuntimeState struct {
+	// TargetTemperature overrides target_temperature if non-zero
+	TargetTemperature int `json:"target_temperature,omitempty"`
+	// ConfiguredTargetTemperature is the target_temperature of the config
+	// file when TargetTemperature was set. If the config file changes it,
+	// the override is discarded.
+	ConfiguredTargetTemperature int `json:"configured_target_temperature,omitempty"`
+	// RoomTargets overrides the target temperature of single rooms, keyed by
+	// Velux home and room ID
+	RoomTargets map[string]int `json:"room_targets,omitempty"`
+	// Away sends the away target temperature for all rooms
+	Away bool `json:"away,omitempty"`
+	// Alarms holds the IDs of the active NIBE alarms which were already
+	// reported, by system, so they are not reported again after a restart
+	Alarms map[int][]int `json:"alarms,omitempty"`
+}
+
+// loadRuntimeState reads the state file at path. A missing file yields an
+// empty state.
+func loadRuntimeState(path string) (RuntimeState, error) {
+	var rs RuntimeState
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return rs, nil
+	} else if err != nil {
+		return rs, err
+	}
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return rs, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return rs, nil
+}
+
+func (rs *RuntimeState) save(path string) error {
+	data, err := json.MarshalIndent(rs, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0600)
+}
+
+// applyRuntimeState merges the runtime state over the settings from the
+// config file.
+func (s *SystemSettings) applyRuntimeState(rs *RuntimeState) {
+	if rs.TargetTemperature != 0 {
+		s.TargetTemperature = rs.TargetTemperature
+	}
+}
+
+// setSettings replaces the settings with the ones read from the config file
+// and merges the runtime state over them. If target_temperature was changed
+// in the config file since the target temperature was set in the HTML
+// interface, the latter is discarded. The caller must hold SettingsMu.
+func (state *SystemState) setSettings(settings SystemSettings) {
+	state.Settings = settings
+	state.configuredTarget = settings.TargetTemperature
+
+	// state files written by older versions don't record the configured
+	// value, so keep their override
+	rs := &state.Runtime
+	if rs.TargetTemperature != 0 && rs.ConfiguredTargetTemperature != 0 && rs.ConfiguredTargetTemperature != settings.TargetTemperature {
+		slog.Warn("target_temperature was changed in the config file, discarding the target temperature set in the HTML interface",
+			"configured", settings.TargetTemperature, "discarded", rs.TargetTemperature)
+		err := state.updateRuntimeState(func(rs *RuntimeState) {
+			rs.TargetTemperature = 0
+			rs.ConfiguredTargetTemperature = 0
+		})
+		if err != nil {
+			slog.Error("Failed to discard the target temperature override", "error", err)
+			rs.TargetTemperature = 0
+			rs.ConfiguredTargetTemperature = 0
+		}
+	}
+	state.Settings.applyRuntimeState(rs)
+}
+
+// awayTargetTemperature returns the target temperature sent in away mode.
+func (s *SystemSettings) awayTargetTemperature() int {
+	if s.AwayTargetTemperature != 0 {
+		return s.AwayTargetTemperature
+	}
+	return defaultAwayTargetTemperature
+}
+
+// roomTargetTemperature returns the target temperature of the given room. The
+// caller must hold SettingsMu.
+func (state *SystemState) roomTargetTemperature(system int, homeID, roomID string) int {
+	if t := state.Runtime.RoomTargets[roomKey(homeID, roomID)]; t != 0 {
+		return t
+	}
+	if state.Runtime.Away {
+		return state.Settings.awayTargetTemperature()
+	}
+	return state.Settings.targetTemperature(system)
+}
+
+// updateRuntimeState applies fn to a copy of the runtime state, persists it
+// and merges it over the settings. The caller must hold SettingsMu.
+func (state *SystemState) updateRuntimeState(fn func(rs *RuntimeState)) error {
+	rs := state.Runtime
+	rs.RoomTargets = make(map[string]int, len(state.Runtime.RoomTargets))
+	for key, t := range state.Runtime.RoomTargets {
+		rs.RoomTargets[key] = t
+	}
+	rs.Alarms = make(map[int][]int, len(state.Runtime.Alarms))
+	for system, ids := range state.Runtime.Alarms {
+		rs.Alarms[system] = ids
+	}
+	fn(&rs)
+
+	if err := rs.save(state.Settings.StateFile); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	state.Runtime = rs
+	state.Settings.applyRuntimeState(&rs)
+	return nil
+}
+
+// RoomTargetFormHandler handles the room target temperature form of the HTML
+// interface. An empty target temperature removes the override.
+func (state *SystemState) RoomTargetFormHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		fmt.Fprintf(w, "ParseForm() err: %v", err)
+		return
+	}
+	var target int
+	if s := strings.TrimSpace(r.FormValue("target_temperature")); s != "" {
+		var err error
+		target, err = strconv.Atoi(s)
+		if err != nil {
+			fmt.Fprintf(w, "Invalid temperature: %v", err)
+			return
+		}
+		if !validTargetTemperature(target) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid temperature: %d is outside of %d to %d", target, minTargetTemperature, maxTargetTemperature)
+			return
+		}
+	}
+	key := roomKey(r.FormValue("home_id"), r.FormValue("room_id"))
+
+	state.SettingsMu.Lock()
+	err := state.updateRuntimeState(func(rs *RuntimeState) {
+		if target == 0 {
+			delete(rs.RoomTargets, key)
+		} else {
+			rs.RoomTargets[key] = target
+		}
+	})
+	state.SettingsMu.Unlock()
+	if err != nil {
+		fmt.Fprintf(w, "Failed to set room target temperature: %v", err)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// AwayFormHandler switches away mode on or off.
+func (state *SystemState) AwayFormHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		fmt.Fprintf(w, "ParseForm() err: %v", err)
+		return
+	}
+	away, err := strconv.ParseBool(r.FormValue("away"))
+	if err != nil {
+		fmt.Fprintf(w, "Invalid away mode: %v", err)
+		return
+	}
+
+	state.SettingsMu.Lock()
+	err = state.updateRuntimeState(func(rs *RuntimeState) {
+		rs.Away = away
+	})
+	state.SettingsMu.Unlock()
+	if err != nil {
+		fmt.Fprintf(w, "Failed to set away mode: %v", err)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
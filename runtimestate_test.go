@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetSettingsTargetOverride(t *testing.T) {
+	tests := []struct {
+		name       string
+		runtime    RuntimeState
+		configured int
+		// want is the resulting target temperature and override
+		want, wantOverride int
+	}{
+		{"no override", RuntimeState{}, 215, 215, 0},
+		{"config unchanged", RuntimeState{TargetTemperature: 200, ConfiguredTargetTemperature: 210}, 210, 200, 200},
+		{"config changed", RuntimeState{TargetTemperature: 200, ConfiguredTargetTemperature: 210}, 215, 215, 0},
+		{"older state file", RuntimeState{TargetTemperature: 200}, 215, 200, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := validSettings()
+			settings.TargetTemperature = tt.configured
+			settings.StateFile = filepath.Join(t.TempDir(), "state.json")
+			if err := tt.runtime.save(settings.StateFile); err != nil {
+				t.Fatal(err)
+			}
+			state := &SystemState{Runtime: tt.runtime}
+
+			state.setSettings(settings)
+
+			if state.Settings.TargetTemperature != tt.want || state.Runtime.TargetTemperature != tt.wantOverride {
+				t.Errorf("got target %d with override %d, want %d with override %d",
+					state.Settings.TargetTemperature, state.Runtime.TargetTemperature, tt.want, tt.wantOverride)
+			}
+			if state.configuredTarget != tt.configured {
+				t.Errorf("got configured target %d, want %d", state.configuredTarget, tt.configured)
+			}
+			saved, err := loadRuntimeState(settings.StateFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if saved.TargetTemperature != tt.wantOverride {
+				t.Errorf("got override %d in the state file, want %d", saved.TargetTemperature, tt.wantOverride)
+			}
+		})
+	}
+}
+
+func TestSetTargetTemperatureRecordsConfiguredValue(t *testing.T) {
+	settings := validSettings()
+	settings.StateFile = filepath.Join(t.TempDir(), "state.json")
+	state := &SystemState{}
+	state.setSettings(settings)
+
+	if err := state.setTargetTemperature(225); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := loadRuntimeState(settings.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.TargetTemperature != 225 || saved.ConfiguredTargetTemperature != settings.TargetTemperature {
+		t.Errorf("got state %+v, want the override and the configured %d", saved, settings.TargetTemperature)
+	}
+	if err := state.setTargetTemperature(50); err == nil || errorStatus(err) != http.StatusBadRequest {
+		t.Errorf("got %v for an invalid temperature, want a request error", err)
+	}
+}
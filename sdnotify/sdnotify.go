@@ -0,0 +1,67 @@
+// Package sdnotify implements the systemd service notification protocol, see
+// sd_notify(3). All functions do nothing unless the process was started by
+// systemd with NotifyAccess set, i.e. NOTIFY_SOCKET is set.
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Well-known notification states.
+const (
+	// Ready tells systemd that the service finished starting up.
+	Ready = "READY=1"
+	// Stopping tells systemd that the service is shutting down.
+	Stopping = "STOPPING=1"
+	// Watchdog resets the watchdog timer.
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends the given states to systemd. It reports whether the
+// notification was sent, which is false without an error if NOTIFY_SOCKET is
+// not set.
+func Notify(states ...string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// abstract socket addresses start with a null byte instead of @
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Status returns the state which sets the free-form status shown by
+// systemctl status. Newlines are not allowed and replaced with spaces.
+func Status(status string) string {
+	return "STATUS=" + strings.ReplaceAll(status, "\n", " ")
+}
+
+// WatchdogInterval returns the watchdog timeout configured with WatchdogSec,
+// or false if the watchdog is disabled or meant for a different process.
+// systemd recommends pinging the watchdog every half of the interval.
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
@@ -0,0 +1,99 @@
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listen creates a notification socket at path and returns a function
+// reading the next notification from it.
+func listen(t *testing.T, path string) func() string {
+	t.Helper()
+	name := path
+	if name[0] == '@' {
+		name = "\x00" + name[1:]
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	return func() string {
+		t.Helper()
+		buf := make([]byte, 4096)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+}
+
+func TestNotify(t *testing.T) {
+	read := listen(t, filepath.Join(t.TempDir(), "notify.sock"))
+
+	tests := []struct {
+		states []string
+		want   string
+	}{
+		{[]string{Ready}, "READY=1"},
+		{[]string{Watchdog, Status("Synced 3 rooms\nof 4")}, "WATCHDOG=1\nSTATUS=Synced 3 rooms of 4"},
+		{[]string{Stopping}, "STOPPING=1"},
+	}
+	for _, tt := range tests {
+		sent, err := Notify(tt.states...)
+		if err != nil || !sent {
+			t.Fatalf("Notify(%q) = %v, %v", tt.states, sent, err)
+		}
+		if got := read(); got != tt.want {
+			t.Errorf("Notify(%q) sent %q, want %q", tt.states, got, tt.want)
+		}
+	}
+}
+
+func TestNotifyAbstractSocket(t *testing.T) {
+	read := listen(t, "@velux-nibe-test-"+strconv.Itoa(os.Getpid()))
+
+	if sent, err := Notify(Ready); err != nil || !sent {
+		t.Fatalf("Notify = %v, %v", sent, err)
+	}
+	if got := read(); got != Ready {
+		t.Errorf("sent %q, want %q", got, Ready)
+	}
+}
+
+func TestNotifyWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(Ready); sent || err != nil {
+		t.Errorf("Notify = %v, %v, want false, nil", sent, err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+		ok        bool
+	}{
+		{"", "", 0, false},
+		{"600000000", "", 10 * time.Minute, true},
+		{"600000000", strconv.Itoa(os.Getpid()), 10 * time.Minute, true},
+		{"600000000", "1", 0, false},
+		{"invalid", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got, ok := WatchdogInterval(); got != tt.want || ok != tt.ok {
+			t.Errorf("WatchdogInterval() with WATCHDOG_USEC=%q, WATCHDOG_PID=%q = %v, %v, want %v, %v",
+				tt.usec, tt.pid, got, ok, tt.want, tt.ok)
+		}
+	}
+}
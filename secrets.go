@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envReference matches ${NAME} references to environment variables in string
+// values of the config file, and the escape $${ for a literal ${.
+var envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces environment variable references in all string values of
+// a decoded JSON document. Referencing an undefined variable is an error, so
+// a missing secret doesn't silently turn into an empty one.
+func expandEnv(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		var errs []error
+		s := envReference.ReplaceAllStringFunc(v, func(ref string) string {
+			if ref == "$${" {
+				return "${"
+			}
+			name := envReference.FindStringSubmatch(ref)[1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				errs = append(errs, fmt.Errorf("environment variable %s referenced in the config file is not set", name))
+			}
+			return value
+		})
+		return s, errors.Join(errs...)
+	case map[string]interface{}:
+		var errs []error
+		for key, value := range v {
+			expanded, err := expandEnv(value)
+			errs = append(errs, err)
+			v[key] = expanded
+		}
+		return v, errors.Join(errs...)
+	case []interface{}:
+		var errs []error
+		for i, value := range v {
+			expanded, err := expandEnv(value)
+			errs = append(errs, err)
+			v[i] = expanded
+		}
+		return v, errors.Join(errs...)
+	default:
+		return v, nil
+	}
+}
+
+// secretFile is a secret which may be read from a file instead.
+type secretFile struct {
+	name   string
+	file   string
+	secret *string
+}
+
+// readSecretFiles replaces the secrets configured as files with the contents
+// of these files.
+func (s *SystemSettings) readSecretFiles() error {
+	secrets := []secretFile{
+		{"velux_password", s.PasswordFile, &s.Password},
+		{"nibe_client_secret", s.ClientSecretFile, &s.ClientSecret},
+		{"http_auth.api_token", s.HTTPAuth.APITokenFile, &s.HTTPAuth.APIToken},
+	}
+	for i, n := range s.Alerts.Ntfy {
+		secrets = append(secrets, secretFile{fmt.Sprintf("alerts.ntfy[%d].token", i), n.TokenFile, &n.Token})
+	}
+	for i, g := range s.Alerts.Gotify {
+		secrets = append(secrets, secretFile{fmt.Sprintf("alerts.gotify[%d].token", i), g.TokenFile, &g.Token})
+	}
+	for i, m := range s.Alerts.SMTP {
+		secrets = append(secrets, secretFile{fmt.Sprintf("alerts.smtp[%d].password", i), m.PasswordFile, &m.Password})
+	}
+	for _, secret := range secrets {
+		if secret.file == "" {
+			continue
+		}
+		if *secret.secret != "" {
+			return fmt.Errorf("%s and %s_file are mutually exclusive", secret.name, secret.name)
+		}
+		data, err := os.ReadFile(secret.file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s_file: %w", secret.name, err)
+		}
+		// secrets created with echo or an editor end with a newline
+		*secret.secret = strings.TrimRight(string(data), "\r\n")
+	}
+	return nil
+}
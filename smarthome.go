@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/ingmarstein/velux-nibe/nibe"
+)
+
+// SmartHomeStatus describes the smart home mode of a NIBE system as last seen
+// by velux-nibe.
+type SmartHomeStatus struct {
+	Mode nibe.SmartHomeMode `json:"mode"`
+	// Warning is set if smart home mode appears to be disabled.
+	Warning string `json:"warning,omitempty"`
+}
+
+// checkSmartHomeModes reads the smart home mode of the given systems. Systems
+// whose mode can't be read most likely don't have the smart home plus function
+// enabled, which means the thermostats reported by velux-nibe are ignored.
+func (state *SystemState) checkSmartHomeModes(ctx context.Context, systems []int) {
+	for _, system := range systems {
+		status := SmartHomeStatus{}
+		response, err := state.nibeClient.GetSmartHomeMode(ctx, nibe.GetSmartHomeModeRequest{SystemID: system})
+		switch {
+		case err != nil:
+			status.Warning = fmt.Sprintf("Unable to read the smart home mode (%v). Make sure smart home is enabled in NIBE Uplink under Manage > heat pump > plus functions > smart home.", err)
+		case response.Mode == "":
+			status.Warning = "Smart home mode is off. Enable it in NIBE Uplink under Manage > heat pump > plus functions > smart home."
+		default:
+			status.Mode = response.Mode
+		}
+		if status.Warning != "" {
+			nibeLog.Warn(status.Warning, "system", system)
+		} else {
+			nibeLog.Info("Smart home mode", "system", system, "mode", status.Mode)
+		}
+
+		state.SmartHomeMu.Lock()
+		state.SmartHome[system] = status
+		state.SmartHomeMu.Unlock()
+	}
+}
+
+// refreshSmartHomeModes reads the smart home mode of systems which were added
+// to the settings and forgets the ones which were removed.
+func (state *SystemState) refreshSmartHomeModes(ctx context.Context, systems []int) {
+	var added []int
+	state.SmartHomeMu.Lock()
+	for system := range state.SmartHome {
+		if !slices.Contains(systems, system) {
+			delete(state.SmartHome, system)
+		}
+	}
+	for _, system := range systems {
+		if _, ok := state.SmartHome[system]; !ok {
+			added = append(added, system)
+		}
+	}
+	state.SmartHomeMu.Unlock()
+
+	state.checkSmartHomeModes(ctx, added)
+}
+
+// setSmartHomeMode changes the smart home mode of a configured NIBE system.
+func (state *SystemState) setSmartHomeMode(ctx context.Context, system int, mode nibe.SmartHomeMode) error {
+	if !mode.Valid() {
+		return invalidRequest("invalid smart home mode %q", mode)
+	}
+	state.SettingsMu.RLock()
+	configured := slices.Contains(state.Settings.configuredSystems(), system)
+	state.SettingsMu.RUnlock()
+	if !configured {
+		return invalidRequest("NIBE system %d is not configured", system)
+	}
+
+	if err := state.nibeClient.SetSmartHomeMode(ctx, nibe.SetSmartHomeModeRequest{SystemID: system, Mode: mode}); err != nil {
+		return err
+	}
+	nibeLog.Info("Smart home mode changed", "system", system, "mode", mode)
+
+	state.SmartHomeMu.Lock()
+	state.SmartHome[system] = SmartHomeStatus{Mode: mode}
+	state.SmartHomeMu.Unlock()
+	return nil
+}
+
+// SmartHomeModeFormHandler handles the smart home mode form of the HTML
+// interface.
+func (state *SystemState) SmartHomeModeFormHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		fmt.Fprintf(w, "ParseForm() err: %v", err)
+		return
+	}
+	system, err := strconv.Atoi(r.FormValue("system"))
+	if err != nil {
+		fmt.Fprintf(w, "Invalid system: %v", err)
+		return
+	}
+	if err := state.setSmartHomeMode(r.Context(), system, nibe.SmartHomeMode(r.FormValue("mode"))); err != nil {
+		fmt.Fprintf(w, "Failed to set smart home mode: %v", err)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// SmartHomeModeAPIHandler handles GET and PUT requests for
+// /api/systems/{system}/smarthome/mode.
+func (state *SystemState) SmartHomeModeAPIHandler(w http.ResponseWriter, r *http.Request) {
+	system, err := strconv.Atoi(r.PathValue("system"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid system: %w", err))
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		var request struct {
+			Mode nibe.SmartHomeMode `json:"mode"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := state.setSmartHomeMode(r.Context(), system, request.Mode); err != nil {
+			writeJSONError(w, errorStatus(err), err)
+			return
+		}
+	}
+
+	state.SmartHomeMu.RLock()
+	status, ok := state.SmartHome[system]
+	state.SmartHomeMu.RUnlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("NIBE system %d is not configured", system))
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
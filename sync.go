@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ingmarstein/velux-nibe/nibe"
+	"github.com/ingmarstein/velux-nibe/velux"
+)
+
+// syncSummary describes the outcome of the last sync cycle in a single line.
+func (state *SystemState) syncSummary(err error) string {
+	state.UpdatesMu.RLock()
+	rooms := len(state.LastUpdate)
+	state.UpdatesMu.RUnlock()
+
+	summary := fmt.Sprintf("Last sync at %s: %d rooms", time.Now().Format("15:04:05"), rooms)
+	if err != nil {
+		errs := strings.Split(err.Error(), "\n")
+		summary += fmt.Sprintf(", %d errors, first: %s", len(errs), errs[0])
+	}
+	return summary
+}
+
+// syncOnce reads the rooms of all Velux homes and reports them as thermostats
+// to the NIBE systems the homes are mapped to. The returned error combines
+// all failures of the cycle.
+func (state *SystemState) syncOnce(ctx context.Context) error {
+	homeData, err := state.veluxClient.GetHomesData(ctx, velux.GetHomesDataRequest{GatewayTypes: []string{velux.Bridge}})
+	state.veluxHealth.record(err)
+	if err != nil {
+		veluxLog.Error("Failed to get home data", "error", err)
+		return fmt.Errorf("error getting home data: %w", err)
+	}
+
+	var errs []error
+	var updates []UpdateResult
+
+	for _, home := range homeData.Body.Homes {
+		state.SettingsMu.RLock()
+		systemID, ok := state.Settings.homeSystem(home.ID)
+		state.SettingsMu.RUnlock()
+		if !ok {
+			syncLog.Warn("Home is not mapped to a NIBE system, skipping", "home", home.Name, "home_id", home.ID)
+			continue
+		}
+
+		roomNames := make(map[string]string)
+		for _, room := range home.Rooms {
+			roomNames[room.ID] = room.Name
+		}
+
+		status, err := state.veluxClient.HomeStatus(ctx, velux.HomeStatusRequest{
+			HomeID:      home.ID,
+			DeviceTypes: []string{velux.Sensor},
+		})
+		state.veluxHealth.record(err)
+		if err != nil {
+			veluxLog.Error("Failed to get home status", "home", home.Name, "error", err)
+			errs = append(errs, fmt.Errorf("home %s: %w", home.Name, err))
+			continue
+		}
+		for _, room := range status.Body.Home.Rooms {
+			roomName, ok := roomNames[room.ID]
+			if !ok {
+				roomName = room.ID
+			}
+
+			syncLog.Info("Room", "home", home.Name, "room", roomName, "temperature", room.Temperature)
+			if room.Temperature == 0 {
+				syncLog.Debug("Room has no temperature, skipping", "home", home.Name, "room", roomName)
+				continue
+			}
+			state.checkRoomComfort(home.ID, room.ID, home.Name, roomName, room.Temperature, room.MinComfortTemperature)
+
+			externalId, err := state.externalIDs.Lookup(home.ID, room.ID)
+			if err != nil {
+				syncLog.Error("Failed to allocate thermostat ID", "home", home.Name, "room", roomName, "error", err)
+				errs = append(errs, fmt.Errorf("home %s - room %s: %w", home.Name, roomName, err))
+				continue
+			}
+			state.SettingsMu.RLock()
+			temp := state.roomTargetTemperature(systemID, home.ID, room.ID)
+			climateSystems := state.Settings.climateSystems(systemID)
+			state.SettingsMu.RUnlock()
+			err = state.nibeClient.SetThermostat(ctx, nibe.SetThermostatRequest{
+				SystemID:       systemID,
+				ExternalId:     externalId,
+				Name:           roomName,
+				ActualTemp:     room.Temperature,
+				TargetTemp:     temp,
+				ClimateSystems: climateSystems,
+			})
+			state.nibeHealth.record(err)
+			updates = append(updates, UpdateResult{
+				Timestamp:         time.Now(),
+				HomeID:            home.ID,
+				Home:              home.Name,
+				RoomID:            room.ID,
+				Name:              roomName,
+				System:            systemID,
+				ActualTemperature: room.Temperature,
+				TargetTemperature: temp,
+				Humidity:          room.Humidity,
+				CO2:               room.CO2,
+				Result:            err,
+			})
+			if err != nil {
+				nibeLog.Error("Failed to set thermostat", "thermostat", externalId, "room", roomName, "system", systemID, "error", err)
+				errs = append(errs, fmt.Errorf("room %s: %w", roomName, err))
+			}
+		}
+		state.UpdatesMu.Lock()
+		state.LastUpdate = updates
+		state.UpdatesMu.Unlock()
+	}
+
+	state.recordHistory(updates)
+
+	// the rooms are known from the home data even if reading the status
+	// of a home failed
+	state.SettingsMu.RLock()
+	current := currentRooms(homeData, &state.Settings, state.externalIDs)
+	state.SettingsMu.RUnlock()
+	state.recordRooms(ctx, current)
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ingmarstein/velux-nibe/alert"
+	"github.com/ingmarstein/velux-nibe/nibe"
+	"github.com/ingmarstein/velux-nibe/replay"
+	"github.com/ingmarstein/velux-nibe/velux"
+	"golang.org/x/oauth2"
+)
+
+// Run with -record to record the fixtures using the credentials passed in the
+// same environment variables as velux-nibe. The tests expect system 12345.
+var record = flag.Bool("record", false, "record fixtures from the Velux and NIBE Uplink APIs")
+
+// newTestState returns a state whose clients replay the fixtures with the
+// given prefix.
+func newTestState(t *testing.T, fixture string) *SystemState {
+	t.Helper()
+
+	veluxClient := replay.ForTest(t, filepath.Join("testdata", fixture+"_velux.json"), *record, func() http.RoundTripper {
+		hc, err := velux.NewAuthClient(os.Getenv("VELUX_USERNAME"), os.Getenv("VELUX_PASSWORD"))
+		if err != nil {
+			t.Fatalf("failed to authenticate with Velux: %v", err)
+		}
+		return hc.Transport
+	})
+	nibeClient := replay.ForTest(t, filepath.Join("testdata", fixture+"_nibe.json"), *record, func() http.RoundTripper {
+		conf := &oauth2.Config{
+			ClientID:     os.Getenv("NIBE_CLIENT_ID"),
+			ClientSecret: os.Getenv("NIBE_CLIENT_SECRET"),
+			Endpoint:     nibe.Endpoint,
+			RedirectURL:  os.Getenv("NIBE_CALLBACK_URL"),
+			Scopes:       []string{nibe.ScopeWrite},
+		}
+		return nibe.GetAuthClient(conf, os.Getenv("NIBE_TOKEN")).Transport
+	})
+
+	externalIDs, err := LoadExternalIDs(filepath.Join(t.TempDir(), "ids.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &SystemState{
+		Settings: SystemSettings{
+			System:            12345,
+			PollInterval:      60,
+			TargetTemperature: 210,
+		},
+		SmartHome:       make(map[int]SmartHomeStatus),
+		Telemetry:       make(map[int]SystemTelemetry),
+		Alarms:          make(map[int][]nibe.Notification),
+		intervalChanged: make(chan struct{}, 1),
+		nibeClient:      nibe.NewClient(nibeClient),
+		veluxClient:     velux.NewClient(veluxClient),
+		externalIDs:     externalIDs,
+		alerts:          alert.NewManager(),
+	}
+}
+
+func TestSyncOnce(t *testing.T) {
+	state := newTestState(t, "sync")
+	// the bedroom had a reading before
+	bedroom, err := state.externalIDs.Lookup("5e1f8a8b2d3e4f0012345678", "2345678901")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := state.syncOnce(context.Background()); err != nil {
+		t.Fatalf("syncOnce: %v", err)
+	}
+
+	// the bedroom has no sensor and is skipped
+	if len(state.LastUpdate) != 1 {
+		t.Fatalf("got %d updates, want 1", len(state.LastUpdate))
+	}
+	u := state.LastUpdate[0]
+	if u.Name != "Living room" || u.System != 12345 || u.ActualTemperature != 215 || u.TargetTemperature != 210 || u.Humidity != 48 || u.CO2 != 640 || u.Result != nil {
+		t.Errorf("got update %+v, want Living room with 21.5 °C, target 21 °C, 48%% and 640 ppm", u)
+	}
+	if !state.externalIDs.Contains(1234567890) {
+		t.Error("numeric room ID was not used as thermostat ID")
+	}
+	if !state.veluxHealth.status().Ready || !state.nibeHealth.status().Ready {
+		t.Error("Velux or NIBE reported as not ready after a successful sync")
+	}
+
+	// thermostat 7 was created by velux-nibe, but its room is gone; thermostat
+	// 3 belongs to another smart home system
+	if len(state.StaleThermostats) != 1 || state.StaleThermostats[0].ExternalID != 7 {
+		t.Errorf("got stale thermostats %+v, want 7", state.StaleThermostats)
+	}
+	if current := state.roomIDs()[12345]; !current[1234567890] || !current[bedroom] {
+		t.Errorf("got current thermostats %v, want the living room and the bedroom without a reading", current)
+	}
+}
+
+func TestSyncOnceAwayWithNIBEError(t *testing.T) {
+	state := newTestState(t, "sync_away")
+	state.Runtime.Away = true
+
+	err := state.syncOnce(context.Background())
+	if err == nil {
+		t.Fatal("syncOnce succeeded although NIBE Uplink failed")
+	}
+	if want := "room Living room: POST /api/v1/systems/12345/smarthome/thermostats: 500 Internal server error"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+
+	if len(state.LastUpdate) != 1 {
+		t.Fatalf("got %d updates, want 1", len(state.LastUpdate))
+	}
+	if u := state.LastUpdate[0]; u.TargetTemperature != defaultAwayTargetTemperature || u.Result == nil {
+		t.Errorf("got update %+v, want the away target temperature and the error", u)
+	}
+	if !state.veluxHealth.status().Ready {
+		t.Error("Velux reported as not ready although it succeeded")
+	}
+	if state.nibeHealth.status().Ready {
+		t.Error("NIBE reported as ready although it failed")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ingmarstein/velux-nibe/history"
+	"github.com/ingmarstein/velux-nibe/nibe"
+)
+
+// defaultTelemetryParameters are collected if no parameters are configured.
+var defaultTelemetryParameters = []nibe.ParameterID{
+	nibe.ParameterOutdoorTemperature,
+	nibe.ParameterSupplyTemperature,
+	nibe.ParameterCalculatedSupplyTemperature,
+	nibe.ParameterReturnTemperature,
+	nibe.ParameterDegreeMinutes,
+	nibe.ParameterHotWaterTopTemperature,
+}
+
+// SystemTelemetry holds what a NIBE system reported at a given time.
+type SystemTelemetry struct {
+	Timestamp time.Time
+	System    int
+	// Components lists the system components which are currently active,
+	// e.g. the compressor or the hot water production.
+	Components []string
+	Parameters []nibe.Parameter
+	Result     error
+}
+
+func (t SystemTelemetry) reading() history.Reading {
+	r := history.Reading{
+		Time:       t.Timestamp,
+		System:     t.System,
+		Components: t.Components,
+	}
+	for _, p := range t.Parameters {
+		// the display value is scaled, unlike the raw value
+		value, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(p.DisplayValue, p.Unit)), 64)
+		if err != nil {
+			continue
+		}
+		r.Parameters = append(r.Parameters, history.Parameter{Name: p.Title, Unit: p.Unit, Value: value})
+	}
+	if t.Result != nil {
+		r.Result = t.Result.Error()
+	}
+	return r
+}
+
+// telemetryParameters returns the parameters collected by the telemetry
+// collector.
+func (s *SystemSettings) telemetryParameters() []nibe.ParameterID {
+	if len(s.TelemetryParameters) > 0 {
+		return s.TelemetryParameters
+	}
+	return defaultTelemetryParameters
+}
+
+// telemetryInterval returns the interval of the telemetry collector, or 0 if
+// it is disabled.
+func (s *SystemSettings) telemetryInterval() time.Duration {
+	switch {
+	case s.TelemetryInterval < 0:
+		return 0
+	case s.TelemetryInterval == 0:
+		return time.Duration(s.PollInterval) * time.Second
+	default:
+		return time.Duration(s.TelemetryInterval) * time.Second
+	}
+}
+
+// collectTelemetry periodically reads the status and selected parameters of
+// all configured NIBE systems so they can be shown and recorded next to the
+// room readings. While telemetry is disabled, it checks every
+// configCheckInterval whether reloaded settings enabled it.
+func (state *SystemState) collectTelemetry(ctx context.Context) {
+	// no valid interval, so the ticker is set up in the first iteration
+	interval := time.Duration(-1)
+	ticker := time.NewTicker(configCheckInterval)
+	defer ticker.Stop()
+	for {
+		state.SettingsMu.RLock()
+		systems := state.Settings.configuredSystems()
+		parameters := state.Settings.telemetryParameters()
+		i := state.Settings.telemetryInterval()
+		state.SettingsMu.RUnlock()
+
+		// pick up interval changes from reloaded settings
+		if i != interval {
+			if i > 0 {
+				ticker.Reset(i)
+			} else {
+				nibeLog.Info("Heat pump telemetry is disabled")
+				ticker.Reset(configCheckInterval)
+			}
+			interval = i
+		}
+
+		if interval > 0 {
+			for _, system := range systems {
+				state.recordTelemetry(readTelemetry(ctx, state.nibeClient, system, parameters))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordTelemetry shows the telemetry in the HTML interface and appends it to
+// the history.
+func (state *SystemState) recordTelemetry(telemetry SystemTelemetry) {
+	if telemetry.Result != nil {
+		nibeLog.Error("Failed to read telemetry", "system", telemetry.System, "error", telemetry.Result)
+	}
+
+	state.UpdatesMu.Lock()
+	state.Telemetry[telemetry.System] = telemetry
+	state.UpdatesMu.Unlock()
+
+	if state.history != nil {
+		if err := state.history.Append(telemetry.reading()); err != nil {
+			nibeLog.Error("Failed to write telemetry to history", "error", err)
+		}
+	}
+}
+
+func readTelemetry(ctx context.Context, client *nibe.Client, system int, parameters []nibe.ParameterID) SystemTelemetry {
+	telemetry := SystemTelemetry{Timestamp: time.Now(), System: system}
+
+	status, err := client.GetSystemStatus(ctx, nibe.GetSystemStatusRequest{SystemID: system})
+	if err != nil {
+		telemetry.Result = err
+		return telemetry
+	}
+	for _, item := range status {
+		telemetry.Components = append(telemetry.Components, item.Title)
+	}
+
+	telemetry.Parameters, telemetry.Result = client.GetSystemParameters(ctx, nibe.GetSystemParametersRequest{
+		SystemID:     system,
+		ParameterIDs: parameters,
+	})
+	return telemetry
+}
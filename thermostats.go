@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/ingmarstein/velux-nibe/nibe"
+	"github.com/ingmarstein/velux-nibe/velux"
+)
+
+// reconcileInterval defines how often the thermostats known to NIBE Uplink
+// are compared to the ones reported by velux-nibe.
+const reconcileInterval = time.Hour
+
+// ThermostatInfo describes a thermostat known to NIBE Uplink.
+type ThermostatInfo struct {
+	nibe.Thermostat
+	System int `json:"system"`
+	// Managed is set if the thermostat was created by velux-nibe rather than
+	// another smart home system.
+	Managed bool `json:"managed"`
+	// Stale is set if the thermostat was created by velux-nibe, but its room
+	// no longer exists.
+	Stale bool `json:"stale"`
+}
+
+// listThermostats returns the thermostats of the given systems. current holds
+// the IDs of the thermostats of the existing Velux rooms by NIBE system, see
+// currentRooms.
+func listThermostats(ctx context.Context, client *nibe.Client, systems []int, externalIDs *ExternalIDs, current map[int]map[int]bool) ([]ThermostatInfo, error) {
+	var result []ThermostatInfo
+	for _, system := range systems {
+		thermostats, err := client.GetThermostats(ctx, nibe.GetThermostatsRequest{SystemID: system})
+		if err != nil {
+			return nil, fmt.Errorf("system %d: %w", system, err)
+		}
+
+		// NIBE Uplink names the source of a thermostat after the API
+		// application, so the sources of velux-nibe are learned from the
+		// thermostats it allocated IDs for
+		managed := make(map[string]bool)
+		for _, t := range thermostats {
+			if externalIDs.Contains(t.ExternalID) {
+				managed[t.SmartHomeSystem] = true
+			}
+		}
+
+		for _, t := range thermostats {
+			info := ThermostatInfo{
+				Thermostat: t,
+				System:     system,
+				Managed:    managed[t.SmartHomeSystem],
+			}
+			info.Stale = info.Managed && !current[system][t.ExternalID]
+			result = append(result, info)
+		}
+	}
+	return result, nil
+}
+
+// currentRooms returns the thermostat IDs of all rooms of the mapped Velux
+// homes, keyed by NIBE system. Rooms without a reading are included, so their
+// thermostats don't appear stale. The caller must hold SettingsMu if settings
+// are shared.
+func currentRooms(homeData velux.GetHomesDataResponse, settings *SystemSettings, externalIDs *ExternalIDs) map[int]map[int]bool {
+	current := make(map[int]map[int]bool)
+	for _, home := range homeData.Body.Homes {
+		system, ok := settings.homeSystem(home.ID)
+		if !ok {
+			continue
+		}
+		for _, room := range home.Rooms {
+			id, ok := externalIDs.Get(home.ID, room.ID)
+			if !ok {
+				continue
+			}
+			if current[system] == nil {
+				current[system] = make(map[int]bool)
+			}
+			current[system][id] = true
+		}
+	}
+	return current
+}
+
+// roomIDs returns the thermostat IDs of the Velux rooms seen in the last sync
+// cycle.
+func (state *SystemState) roomIDs() map[int]map[int]bool {
+	state.UpdatesMu.RLock()
+	defer state.UpdatesMu.RUnlock()
+	return state.currentRooms
+}
+
+// recordRooms remembers the Velux rooms seen in the last sync cycle and
+// periodically reports thermostats created by velux-nibe whose room no longer
+// exists, e.g. because it was deleted or moved to an unmapped home.
+func (state *SystemState) recordRooms(ctx context.Context, current map[int]map[int]bool) {
+	state.UpdatesMu.Lock()
+	state.currentRooms = current
+	due := time.Since(state.lastReconcile) >= reconcileInterval
+	if due {
+		state.lastReconcile = time.Now()
+	}
+	state.UpdatesMu.Unlock()
+
+	if !due {
+		return
+	}
+
+	state.SettingsMu.RLock()
+	systems := state.Settings.configuredSystems()
+	state.SettingsMu.RUnlock()
+
+	thermostats, err := listThermostats(ctx, state.nibeClient, systems, state.externalIDs, current)
+	if err != nil {
+		nibeLog.Error("Failed to list thermostats", "error", err)
+		return
+	}
+
+	var stale []ThermostatInfo
+	for _, t := range thermostats {
+		if t.Stale {
+			stale = append(stale, t)
+			nibeLog.Warn("Thermostat is no longer updated by velux-nibe", "system", t.System, "thermostat", t.ExternalID, "name", t.Name, "last_update", t.LastUpdate)
+		}
+	}
+
+	state.UpdatesMu.Lock()
+	state.StaleThermostats = stale
+	state.UpdatesMu.Unlock()
+}
+
+var thermostatsTemplate = template.Must(template.New("thermostats").Funcs(template.FuncMap{
+	"tenths": formatTenths,
+}).Parse(`
+<!DOCTYPE html>
+<html>
+	<head>
+		<title>Velux-Nibe - Thermostats</title>
+	</head>
+	<body>
+		<h1>NIBE Uplink Thermostats</h1>
+		<p><a href="/">Back</a></p>
+		<p>Stale thermostats are no longer updated by velux-nibe and can be removed in NIBE Uplink under "My Systems > System > Smart Home > Thermostats".</p>
+		<table>
+			<tr><th>System</th><th>ID</th><th>Name</th><th>Source</th><th>Climate systems</th><th>Current</th><th>Target</th><th>Last update</th><th>Status</th></tr>
+			{{range .}}
+			<tr>
+				<td>{{.System}}</td>
+				<td>{{.ExternalID}}</td>
+				<td>{{.Name}}</td>
+				<td>{{.SmartHomeSystem}}</td>
+				<td>{{range $i, $cs := .ClimateSystems}}{{if $i}}, {{end}}{{$cs}}{{end}}</td>
+				<td>{{tenths .CurrentTemperature}}</td>
+				<td>{{tenths .TargetTemperature}}</td>
+				<td>{{.LastUpdate}}</td>
+				<td>{{if .Stale}}stale{{else if .Managed}}active{{else}}other source{{end}}</td>
+			</tr>
+			{{end}}
+		</table>
+	</body>
+</html>
+`))
+
+// formatTenths formats a temperature in deg. Celsius multiplied by 10.
+func formatTenths(v *int) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f °C", float64(*v)/10)
+}
+
+// ThermostatsHandler lists the thermostats known to NIBE Uplink.
+func (state *SystemState) ThermostatsHandler(w http.ResponseWriter, r *http.Request) {
+	state.SettingsMu.RLock()
+	systems := state.Settings.configuredSystems()
+	state.SettingsMu.RUnlock()
+
+	thermostats, err := listThermostats(r.Context(), state.nibeClient, systems, state.externalIDs, state.roomIDs())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list NIBE thermostats: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	if err := thermostatsTemplate.Execute(w, thermostats); err != nil {
+		httpLog.Error("Failed to render thermostats", "error", err)
+	}
+}
+
+// ThermostatsAPIHandler returns the thermostats known to NIBE Uplink as JSON.
+func (state *SystemState) ThermostatsAPIHandler(w http.ResponseWriter, r *http.Request) {
+	state.SettingsMu.RLock()
+	systems := state.Settings.configuredSystems()
+	state.SettingsMu.RUnlock()
+
+	thermostats, err := listThermostats(r.Context(), state.nibeClient, systems, state.externalIDs, state.roomIDs())
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, thermostats)
+}
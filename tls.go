@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// selfSignedValidity defines how long generated certificates are valid.
+const selfSignedValidity = 5 * 365 * 24 * time.Hour
+
+// TLSSettings enables HTTPS for the built-in HTTP server.
+type TLSSettings struct {
+	// CertFile and KeyFile hold the PEM encoded certificate (chain) and
+	// private key. They are reloaded when they change.
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+	// SelfSigned generates a self-signed certificate if CertFile doesn't
+	// exist yet
+	SelfSigned bool `json:"self_signed,omitempty"`
+}
+
+func (t *TLSSettings) enabled() bool {
+	return t.CertFile != "" || t.SelfSigned
+}
+
+// certReloader serves a certificate from files and reloads it when the files
+// are modified, e.g. by a certificate renewal job.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(c.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	keyInfo, err := os.Stat(c.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
+
+// reload loads the certificate. The caller must hold mu, unless c is not
+// shared yet.
+func (c *certReloader) reload() error {
+	certMod, keyMod, err := c.modTimes()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert, c.certMod, c.keyMod = &cert, certMod, keyMod
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. If the files were
+// modified but can't be loaded, e.g. because only one of them has been
+// replaced yet, the previous certificate is served.
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	certMod, keyMod, err := c.modTimes()
+	if err == nil && (!certMod.Equal(c.certMod) || !keyMod.Equal(c.keyMod)) {
+		if err := c.reload(); err != nil {
+			httpLog.Error("Failed to reload TLS certificate, keeping the current one", "error", err)
+			// don't retry until the files are modified again
+			c.certMod, c.keyMod = certMod, keyMod
+		} else {
+			httpLog.Info("Reloaded TLS certificate", "file", c.certFile)
+		}
+	}
+	return c.cert, nil
+}
+
+// tlsConfig returns the TLS configuration of the HTTP server, generating a
+// self-signed certificate first if requested.
+func tlsConfig(settings *TLSSettings) (*tls.Config, error) {
+	if settings.SelfSigned {
+		if _, err := os.Stat(settings.CertFile); errors.Is(err, os.ErrNotExist) {
+			if err := generateSelfSignedCert(settings.CertFile, settings.KeyFile); err != nil {
+				return nil, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+			}
+		}
+	}
+
+	reloader, err := newCertReloader(settings.CertFile, settings.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
+
+// generateSelfSignedCert writes a self-signed certificate valid for the host
+// name, localhost and the IP addresses of the local interfaces.
+func generateSelfSignedCert(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "velux-nibe", Organization: []string{"velux-nibe self-signed"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname != "" && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+		if !strings.Contains(hostname, ".") {
+			template.DNSNames = append(template.DNSNames, hostname+".local")
+		}
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+	httpLog.Info("Generated self-signed certificate", "file", certFile,
+		"names", strings.Join(append(template.DNSNames, ipStrings(template.IPAddresses)...), ", "),
+		"sha256", fmt.Sprintf("%X", sha256.Sum256(der)))
+	return nil
+}
+
+func ipStrings(ips []net.IP) []string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = ip.String()
+	}
+	return s
+}
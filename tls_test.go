@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// generateTestCert writes a self-signed certificate to dir and returns the
+// file names and the DER encoded certificate.
+func generateTestCert(t *testing.T, dir, name string) (certFile, keyFile string, der []byte) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+"-cert.pem")
+	keyFile = filepath.Join(dir, name+"-key.pem")
+	if err := generateSelfSignedCert(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert.Certificate[0]
+}
+
+// replaceFile copies src over dst and moves its modification time forward,
+// as file systems with a coarse resolution may not register the change.
+func replaceFile(t *testing.T, src, dst string, mod time.Time) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(dst, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, first := generateTestCert(t, dir, "first")
+	newCert, newKey, second := generateTestCert(t, dir, "second")
+
+	c, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := func() []byte {
+		t.Helper()
+		cert, err := c.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Certificate[0]
+	}
+	if !bytes.Equal(served(), first) {
+		t.Fatal("the initial certificate is not served")
+	}
+
+	// only the certificate has been replaced, so it doesn't match the key
+	mod := time.Now().Add(time.Minute)
+	replaceFile(t, newCert, certFile, mod)
+	if !bytes.Equal(served(), first) {
+		t.Error("a certificate without its key is served")
+	}
+
+	replaceFile(t, newKey, keyFile, mod.Add(time.Minute))
+	if !bytes.Equal(served(), second) {
+		t.Error("the replaced certificate is not served")
+	}
+}
+
+func TestSelfSignedCert(t *testing.T) {
+	dir := t.TempDir()
+	settings := &TLSSettings{
+		CertFile:   filepath.Join(dir, "cert.pem"),
+		KeyFile:    filepath.Join(dir, "key.pem"),
+		SelfSigned: true,
+	}
+	config, err := tlsConfig(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsCert, err := config.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(tlsCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	if cert.NotBefore.After(now) || cert.NotAfter.Before(now.Add(selfSignedValidity-time.Hour)) {
+		t.Errorf("got validity %v to %v", cert.NotBefore, cert.NotAfter)
+	}
+	if !slices.Equal(cert.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}) {
+		t.Errorf("got extended key usage %v", cert.ExtKeyUsage)
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		t.Errorf("not self-signed: %v", err)
+	}
+	if err := cert.VerifyHostname("localhost"); err != nil {
+		t.Error(err)
+	}
+	if hostname, _ := os.Hostname(); hostname != "" {
+		if err := cert.VerifyHostname(hostname); err != nil {
+			t.Error(err)
+		}
+	}
+	for _, ip := range []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback} {
+		if err := cert.VerifyHostname(ip.String()); err != nil {
+			t.Error(err)
+		}
+	}
+	if fi, err := os.Stat(settings.KeyFile); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("got key file %v, %v, want mode 0600", fi, err)
+	}
+
+	// an existing certificate is kept
+	if _, err := tlsConfig(settings); err != nil {
+		t.Fatal(err)
+	}
+	kept, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(kept.Certificate[0], cert.Raw) {
+		t.Error("the certificate was generated again")
+	}
+}
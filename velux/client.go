@@ -5,13 +5,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/ingmarstein/velux-nibe/redact"
 	"golang.org/x/oauth2"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
+	"os"
 	"strings"
+	"sync/atomic"
 )
 
 const (
@@ -22,13 +24,28 @@ const (
 type Client struct {
 	BaseURL   *url.URL
 	UserAgent string
-	Verbose   bool
+	// Verbose logs all requests and responses at debug level, with
+	// credentials redacted. It may be changed while requests are in flight.
+	Verbose atomic.Bool
+	// Logger receives the log output of the client (default: slog.Default())
+	Logger *slog.Logger
 
 	client *http.Client
 }
 
 // NewClientWithAuth returns a new Velux API client using the supplied credentials.
 func NewClientWithAuth(username, password string) *Client {
+	oauthClient, err := NewAuthClient(username, password)
+	if err != nil {
+		slog.Error("Error retrieving Velux token", "component", "velux", "error", err)
+		os.Exit(1)
+	}
+	return NewClient(oauthClient)
+}
+
+// NewAuthClient returns an http.Client which authenticates its requests with
+// a token retrieved using the supplied credentials.
+func NewAuthClient(username, password string) (*http.Client, error) {
 	conf := &oauth2.Config{
 		ClientID:     clientID,
 		ClientSecret: clientSecret,
@@ -40,11 +57,10 @@ func NewClientWithAuth(username, password string) *Client {
 
 	token, err := conf.PasswordCredentialsToken(ctx, username, password)
 	if err != nil {
-		log.Fatalf("error retrieving token: %v", err)
+		return nil, err
 	}
 
-	oauthClient := conf.Client(context.Background(), token)
-	return NewClient(oauthClient)
+	return conf.Client(context.Background(), token), nil
 }
 
 // NewClient returns a new Velux API client. If a nil httpClient is
@@ -62,6 +78,13 @@ func NewClient(httpClient *http.Client) *Client {
 	return c
 }
 
+func (c *Client) logger() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.Default()
+}
+
 // NewRequest creates an API request. A relative URL can be provided in urlStr,
 // in which case it is resolved relative to the BaseURL of the Client.
 // Relative URLs should always be specified without a preceding slash. If
@@ -102,31 +125,76 @@ func (c *Client) NewRequest(method, urlStr string, body interface{}) (*http.Requ
 	return req, nil
 }
 
-func (c *Client) do(req *http.Request, v interface{}) (*http.Response, error) {
-	if c.Verbose {
-		if d, err := httputil.DumpRequest(req, true); err == nil {
-			log.Println(string(d))
+func (c *Client) do(ctx context.Context, req *http.Request, v interface{}) (*http.Response, error) {
+	req = req.WithContext(ctx)
+
+	if c.Verbose.Load() {
+		if d, err := redact.DumpRequest(req); err == nil {
+			c.logger().Debug("request", "dump", d)
 		}
 	}
 
 	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
 
-	if c.Verbose {
-		if d, err := httputil.DumpResponse(resp, true); err == nil {
-			log.Println(string(d))
+	if c.Verbose.Load() {
+		if d, err := redact.DumpResponse(resp); err == nil {
+			c.logger().Debug("response", "dump", d)
 		}
 	}
 
-	if err != nil {
-		return nil, err
-	}
 	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusNoContent {
+	if err := checkResponse(resp); err != nil {
+		return resp, err
+	}
+	if v != nil && resp.StatusCode != http.StatusNoContent {
 		err = json.NewDecoder(resp.Body).Decode(v)
 	}
 	return resp, err
 }
 
+// ErrorResponse reports an error caused by an API request.
+type ErrorResponse struct {
+	// HTTP response that caused this error
+	Response *http.Response
+	// Error code returned by the Velux API, if any
+	Code int
+	// Error message returned by the Velux API, if any
+	Message string
+}
+
+func (r *ErrorResponse) Error() string {
+	msg := r.Message
+	if msg == "" {
+		msg = http.StatusText(r.Response.StatusCode)
+	}
+	return fmt.Sprintf("%v %v: %d %s", r.Response.Request.Method, r.Response.Request.URL.Path, r.Response.StatusCode, msg)
+}
+
+// checkResponse returns an *ErrorResponse if the status code of resp does
+// not indicate success.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	errorResponse := &ErrorResponse{Response: resp}
+	// the Velux API wraps errors, e.g. {"error":{"code":2,"message":"Invalid access_token"}}
+	var body struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err == nil && len(data) > 0 && json.Unmarshal(data, &body) == nil {
+		errorResponse.Code = body.Error.Code
+		errorResponse.Message = body.Error.Message
+	}
+	return errorResponse
+}
+
 // setOptions set the parameters in opts as URL query parameters to s.
 func setOptions(s string, opts url.Values) (string, error) {
 	if len(opts) == 0 {
@@ -163,7 +231,7 @@ type GetHomesDataResponse struct {
 	} `json:"body"`
 }
 
-func (c *Client) GetHomesData(request GetHomesDataRequest) (GetHomesDataResponse, error) {
+func (c *Client) GetHomesData(ctx context.Context, request GetHomesDataRequest) (GetHomesDataResponse, error) {
 	options := url.Values{}
 	if len(request.GatewayTypes) > 0 {
 		options["gateway_types"] = request.GatewayTypes
@@ -179,7 +247,7 @@ func (c *Client) GetHomesData(request GetHomesDataRequest) (GetHomesDataResponse
 		return GetHomesDataResponse{}, err
 	}
 	var response GetHomesDataResponse
-	_, err = c.do(req, &response)
+	_, err = c.do(ctx, req, &response)
 	return response, err
 }
 
@@ -212,7 +280,7 @@ type HomeStatusResponse struct {
 	} `json:"body"`
 }
 
-func (c *Client) HomeStatus(request HomeStatusRequest) (HomeStatusResponse, error) {
+func (c *Client) HomeStatus(ctx context.Context, request HomeStatusRequest) (HomeStatusResponse, error) {
 	options := url.Values{}
 	options.Set("home_id", request.HomeID)
 	options["device_types"] = request.DeviceTypes
@@ -227,6 +295,6 @@ func (c *Client) HomeStatus(request HomeStatusRequest) (HomeStatusResponse, erro
 		return HomeStatusResponse{}, err
 	}
 	var response HomeStatusResponse
-	_, err = c.do(req, &response)
+	_, err = c.do(ctx, req, &response)
 	return response, err
 }
@@ -0,0 +1,80 @@
+package velux
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/ingmarstein/velux-nibe/replay"
+)
+
+// Run with -record to record the fixtures using the credentials in
+// $VELUX_USERNAME and $VELUX_PASSWORD.
+var record = flag.Bool("record", false, "record fixtures from the Velux API")
+
+// newTestClient returns a client replaying the interactions in the given
+// fixture, or recording them with -record.
+func newTestClient(t *testing.T, fixture string) *Client {
+	t.Helper()
+	return NewClient(replay.ForTest(t, "testdata/"+fixture+".json", *record, func() http.RoundTripper {
+		hc, err := NewAuthClient(os.Getenv("VELUX_USERNAME"), os.Getenv("VELUX_PASSWORD"))
+		if err != nil {
+			t.Fatalf("failed to authenticate: %v", err)
+		}
+		return hc.Transport
+	}))
+}
+
+func TestGetHomesData(t *testing.T) {
+	c := newTestClient(t, "client")
+	ctx := context.Background()
+
+	data, err := c.GetHomesData(ctx, GetHomesDataRequest{GatewayTypes: []string{Bridge}})
+	if err != nil {
+		t.Fatalf("GetHomesData: %v", err)
+	}
+	if len(data.Body.Homes) != 1 {
+		t.Fatalf("got %d homes, want 1", len(data.Body.Homes))
+	}
+	home := data.Body.Homes[0]
+	if home.ID != "5e1f8a8b2d3e4f0012345678" || home.Name != "Home" {
+		t.Errorf("got home %s (%s), want 5e1f8a8b2d3e4f0012345678 (Home)", home.ID, home.Name)
+	}
+	if len(home.Rooms) != 2 || home.Rooms[0].Name != "Living room" || home.Rooms[1].Name != "Bedroom" {
+		t.Errorf("got rooms %+v, want Living room and Bedroom", home.Rooms)
+	}
+
+	status, err := c.HomeStatus(ctx, HomeStatusRequest{HomeID: home.ID, DeviceTypes: []string{Sensor}})
+	if err != nil {
+		t.Fatalf("HomeStatus: %v", err)
+	}
+	rooms := status.Body.Home.Rooms
+	if len(rooms) != 2 {
+		t.Fatalf("got %d rooms, want 2", len(rooms))
+	}
+	if r := rooms[0]; r.ID != "1234567890" || r.Temperature != 215 || r.Humidity != 48 || r.CO2 != 640 || r.MinComfortTemperature != 190 {
+		t.Errorf("got room %+v, want 1234567890 with 21.5 °C, 48%%, 640 ppm and a minimum comfort temperature of 19 °C", r)
+	}
+	if r := rooms[1]; r.Temperature != 0 {
+		t.Errorf("got temperature %d for room without sensor, want 0", r.Temperature)
+	}
+}
+
+func TestError(t *testing.T) {
+	c := newTestClient(t, "error")
+
+	_, err := c.HomeStatus(context.Background(), HomeStatusRequest{HomeID: "unknown", DeviceTypes: []string{Sensor}})
+	var errorResponse *ErrorResponse
+	if !errors.As(err, &errorResponse) {
+		t.Fatalf("got error %v, want *ErrorResponse", err)
+	}
+	if errorResponse.Code != 2 || errorResponse.Message != "Invalid access_token" {
+		t.Errorf("got code %d and message %q, want 2 and the message of the Velux API", errorResponse.Code, errorResponse.Message)
+	}
+	if want := "GET /api/homestatus: 403 Invalid access_token"; err.Error() != want {
+		t.Errorf("got error %q, want %q", err, want)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+)
+
+var htmlTemplate = template.Must(template.New("main").Parse(`
+<!DOCTYPE html>
+<html>
+	<head>
+		<title>Velux-Nibe</title>
+	</head>
+	<body>
+		<h1>Velux-Nibe</h1>
+		<h2>Configuration</h2>
+		{{with .ConfigError}}<p><strong>Warning:</strong> the config file could not be reloaded, the previous settings are still in use: {{.}}</p>{{end}}
+		<table>
+			<tr><td>Velux user</td><td>{{.Settings.Username}}</td></tr>
+			<tr><td>NIBE client ID</td><td>{{.Settings.ClientID}}</td></tr>
+			<tr><td>NIBE system</td><td>{{.Settings.System}}</td></tr>
+			{{range $home, $system := .Settings.Homes}}
+			<tr><td>Velux home {{$home}}</td><td>NIBE system {{$system}}</td></tr>
+			{{end}}
+			<tr><td>Poll interval</td><td>{{.Settings.PollInterval}}</td></tr>
+		</table>
+		{{with .FiringAlerts}}
+		<h2>Alerts</h2>
+		<ul>
+			{{range .}}
+			<li>{{.Time.Format "Jan 02, 2006 15:04:05"}}: <strong>{{.Title}}</strong> {{.Message}}</li>
+			{{end}}
+		</ul>
+		{{end}}
+		{{if .HasAlarms}}
+		<h2>Alarms</h2>
+		{{range $system, $alarms := .Alarms}}
+		{{if $alarms}}
+		<h3>NIBE system {{$system}}</h3>
+		<table>
+			<tr><th>Alarm</th><th>Raised</th><th>Description</th><th>Equipment</th></tr>
+			{{range $alarms}}
+			<tr><td>{{.AlarmNumber}} {{.Header}}</td><td>{{.CreatedAt}}</td><td>{{.Description}}</td><td>{{.EquipName}}</td></tr>
+			{{end}}
+		</table>
+		{{end}}
+		{{end}}
+		{{end}}
+		<h2>Smart Home</h2>
+		{{range $system, $status := .SmartHome}}
+		<h3>NIBE system {{$system}}</h3>
+		{{if $status.Warning}}<p><strong>Warning:</strong> {{$status.Warning}}</p>{{end}}
+		<form method="POST" action="/smarthome/mode">
+			<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+			<input type="hidden" name="system" value="{{$system}}">
+			<label for="mode">Mode:</label>
+			<select name="mode">
+				{{range $.SmartHomeModes}}
+				<option value="{{.}}"{{if eq . $status.Mode}} selected{{end}}>{{.}}</option>
+				{{end}}
+			</select>
+			<input type="submit" value="submit" />
+		</form>
+		{{end}}
+		<h2>Settings</h2>
+		<form method="POST" action="/">
+			<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+			<label for="target_temperature">Target temperature:</label>
+			<input type="text" name="target_temperature" value="{{.Settings.TargetTemperature}}">
+			<input type="submit" value="submit" />
+			{{if .Runtime.TargetTemperature}}
+			<p>Set in this interface, overriding {{.ConfiguredTarget}} from the config file until target_temperature is changed there.</p>
+			{{else}}
+			<p>From the config file.</p>
+			{{end}}
+		</form>
+		<form method="POST" action="/away">
+			<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+			{{if .Runtime.Away}}
+			<p>Away mode is on, all rooms are sent a target temperature of {{.AwayTarget}}.</p>
+			<input type="hidden" name="away" value="false">
+			<input type="submit" value="Switch off away mode" />
+			{{else}}
+			<input type="hidden" name="away" value="true">
+			<input type="submit" value="Switch on away mode" />
+			{{end}}
+		</form>
+		<h2>Thermostats</h2>
+		{{if .StaleThermostats}}
+		<p><strong>Warning:</strong> NIBE Uplink knows about thermostats which are no longer updated by velux-nibe:</p>
+		<ul>
+			{{range .StaleThermostats}}
+			<li>System {{.System}}: {{.Name}} ({{.ExternalID}}), last update {{.LastUpdate}}</li>
+			{{end}}
+		</ul>
+		{{end}}
+		<p><a href="/thermostats">List all thermostats known to NIBE Uplink</a></p>
+		<h2>Heat Pump</h2>
+		{{range $system, $telemetry := .Telemetry}}
+		<h3>NIBE system {{$system}}</h3>
+		<table>
+			<tr><td>Timestamp</td><td>{{$telemetry.Timestamp.Format "Jan 02, 2006 15:04:05 UTC"}}</td></tr>
+			<tr><td>Active components</td><td>{{range $i, $c := $telemetry.Components}}{{if $i}}, {{end}}{{$c}}{{else}}none{{end}}</td></tr>
+			{{range $telemetry.Parameters}}
+			<tr><td>{{.Title}} {{.Designation}}</td><td>{{.DisplayValue}}</td></tr>
+			{{end}}
+			{{if $telemetry.Result}}<tr><td>Result</td><td>{{$telemetry.Result}}</td></tr>{{end}}
+		</table>
+		{{end}}
+		<h2>Last Update</h2>
+		<p><a href="/charts">Charts</a> | Export the last 7 days as <a href="/export?from=-168h&amp;format=csv">CSV</a> or <a href="/export?from=-168h&amp;format=ndjson">JSON</a></p>
+		{{range .LastUpdate}}
+		<h3>Room {{.Name}}</h3>
+		<table>
+			<tr><td>NIBE system</td><td>{{.System}}</td></tr>
+			<tr><td>Timestamp</td><td>{{.Timestamp.Format "Jan 02, 2006 15:04:05 UTC"}}</td></tr>
+			<tr><td>Actual temperature</td><td>{{.ActualTemperature}}</td></tr>
+			<tr><td>Target temperature</td><td>{{.TargetTemperature}}</td></tr>
+			<tr><td>Room target temperature</td><td>
+				<form method="POST" action="/rooms/target">
+					<input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+					<input type="hidden" name="home_id" value="{{.HomeID}}">
+					<input type="hidden" name="room_id" value="{{.RoomID}}">
+					<input type="text" name="target_temperature" value="{{with $.RoomTarget .HomeID .RoomID}}{{.}}{{end}}" placeholder="default">
+					<input type="submit" value="submit" />
+				</form>
+			</td></tr>
+			<tr><td>Result</td><td>{{if .Result}}{{.Result}}{{else}}success{{end}}</td></tr>
+		</table>
+		{{end}}
+  </body>
+</html>
+`))
+
+// statusPage is the data of the HTML interface. It holds a copy of the
+// settings, so rendering the page doesn't race with a reload.
+type statusPage struct {
+	*SystemState
+	Settings    SystemSettings
+	Runtime     RuntimeState
+	ConfigError string
+	// ConfiguredTarget is the target_temperature of the config file
+	ConfiguredTarget int
+}
+
+// AwayTarget returns the target temperature sent in away mode.
+func (p statusPage) AwayTarget() int {
+	return p.Settings.awayTargetTemperature()
+}
+
+// RoomTarget returns the target temperature override of the given room, or 0.
+func (p statusPage) RoomTarget(homeID, roomID string) int {
+	return p.Runtime.RoomTargets[roomKey(homeID, roomID)]
+}
+
+func (state *SystemState) Handler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		if err := r.ParseForm(); err != nil {
+			fmt.Fprintf(w, "ParseForm() err: %v", err)
+			return
+		}
+		newTempString := r.FormValue("target_temperature")
+		newTemp, err := strconv.Atoi(newTempString)
+		if err != nil {
+			fmt.Fprintf(w, "Invalid temperature: %v", err)
+			return
+		}
+		if err := state.setTargetTemperature(newTemp); err != nil {
+			if errors.As(err, new(requestError)) {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+			fmt.Fprint(w, err)
+			return
+		}
+	}
+
+	// updateRuntimeState replaces the runtime maps instead of modifying
+	// them, so a shallow copy is enough
+	state.SettingsMu.RLock()
+	page := statusPage{
+		SystemState:      state,
+		Settings:         state.Settings,
+		Runtime:          state.Runtime,
+		ConfigError:      state.ConfigError,
+		ConfiguredTarget: state.configuredTarget,
+	}
+	state.SettingsMu.RUnlock()
+
+	state.UpdatesMu.RLock()
+	defer state.UpdatesMu.RUnlock()
+	state.SmartHomeMu.RLock()
+	defer state.SmartHomeMu.RUnlock()
+
+	if err := htmlTemplate.Execute(w, page); err != nil {
+		httpLog.Error("Failed to render page", "error", err)
+	}
+}
+
+// setTargetTemperature validates and persists the target temperature set in
+// the HTML interface.
+func (state *SystemState) setTargetTemperature(t int) error {
+	state.SettingsMu.Lock()
+	defer state.SettingsMu.Unlock()
+	settings := state.Settings
+	settings.TargetTemperature = t
+	if err := settings.Validate(); err != nil {
+		return invalidRequest("Invalid settings: %v", err)
+	}
+	if err := state.updateRuntimeState(func(rs *RuntimeState) {
+		rs.TargetTemperature = t
+		rs.ConfiguredTargetTemperature = state.configuredTarget
+	}); err != nil {
+		return fmt.Errorf("Failed to set target temperature: %w", err)
+	}
+	return nil
+}
+
+// routes returns the handler for the HTTP interface.
+func (state *SystemState) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", state.Handler)
+	mux.HandleFunc("GET /healthz", state.HealthzHandler)
+	mux.HandleFunc("GET /readyz", state.ReadyzHandler)
+	mux.HandleFunc("POST /rooms/target", state.RoomTargetFormHandler)
+	mux.HandleFunc("POST /away", state.AwayFormHandler)
+	mux.HandleFunc("POST /smarthome/mode", state.SmartHomeModeFormHandler)
+	mux.HandleFunc("GET /api/systems/{system}/smarthome/mode", state.SmartHomeModeAPIHandler)
+	mux.HandleFunc("PUT /api/systems/{system}/smarthome/mode", state.SmartHomeModeAPIHandler)
+	mux.HandleFunc("PUT /api/systems/{system}/parameters", state.ParametersAPIHandler)
+	mux.HandleFunc("GET /api/alarms", state.AlarmsAPIHandler)
+	mux.HandleFunc("GET /api/history", state.HistoryAPIHandler)
+	mux.HandleFunc("GET /charts", state.ChartsHandler)
+	mux.HandleFunc("GET /export", state.ExportHandler)
+	mux.HandleFunc("GET /thermostats", state.ThermostatsHandler)
+	mux.HandleFunc("GET /api/thermostats", state.ThermostatsAPIHandler)
+	return logRequests(state.requireAuth(state.checkCSRF(mux)))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		httpLog.Error("Failed to write response", "error", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// requestError is an error caused by an invalid request rather than by a
+// failed call to the NIBE or Velux API.
+type requestError struct {
+	error
+}
+
+// invalidRequest returns a requestError with the formatted message.
+func invalidRequest(format string, args ...interface{}) error {
+	return requestError{fmt.Errorf(format, args...)}
+}
+
+// errorStatus returns 400 Bad Request for errors caused by the request and
+// 502 Bad Gateway for all others, which come from the NIBE or Velux API.
+func errorStatus(err error) int {
+	if errors.As(err, new(requestError)) {
+		return http.StatusBadRequest
+	}
+	return http.StatusBadGateway
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ingmarstein/velux-nibe/alert"
+)
+
+// TestHandlerDuringReload renders the page while the config file is reloaded.
+// Run with -race.
+func TestHandlerDuringReload(t *testing.T) {
+	useConfigFile(t, "velux-nibe.yaml", `velux_user: user
+velux_password: secret
+nibe_client_id: id
+nibe_client_secret: secret
+nibe_callback: https://example.com/callback
+nibe_system: 1
+interval: 60
+target_temperature: 215
+`)
+
+	settings, err := readSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &SystemState{
+		Settings:        settings,
+		alerts:          alert.NewManager(),
+		intervalChanged: make(chan struct{}, 1),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			state.reloadSettings(context.Background())
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		w := httptest.NewRecorder()
+		state.Handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `value="215"`) {
+			t.Fatalf("got %d %s", w.Code, w.Body)
+		}
+	}
+	wg.Wait()
+}